@@ -0,0 +1,59 @@
+package messenger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/url"
+
+	"vimagination.zapto.org/errors"
+)
+
+const jsonPrefix = "for (;;);"
+
+type ajaxResponse struct {
+	Error            int             `json:"error"`
+	ErrorSummary     string          `json:"errorSummary"`
+	ErrorDescription string          `json:"errorDescription"`
+	Payload          json.RawMessage `json:"payload"`
+}
+
+func (c *Client) postAJAX(url string, data url.Values, payload interface{}) error {
+	return c.doAJAX(false, url, data, payload)
+}
+
+func (c *Client) readAJAX(url string, data url.Values, payload interface{}) error {
+	return c.doAJAX(true, url, data, payload)
+}
+
+func (c *Client) doAJAX(idempotent bool, url string, data url.Values, payload interface{}) error {
+	return c.withRetry(context.Background(), idempotent, func() error {
+		resp, err := c.postForm(url, data)
+		if err != nil {
+			return err
+		}
+		br := bufio.NewReader(resp.Body)
+		if prefix, err := br.Peek(len(jsonPrefix)); err == nil && string(prefix) == jsonPrefix {
+			br.Discard(len(jsonPrefix))
+		}
+		var ar ajaxResponse
+		err = json.NewDecoder(br).Decode(&ar)
+		resp.Body.Close()
+		if err != nil {
+			return errors.WithContext("error decoding response: ", err)
+		}
+		if ar.Error != 0 {
+			return APIError{
+				Code:        ar.Error,
+				Summary:     ar.ErrorSummary,
+				Description: ar.ErrorDescription,
+			}
+		}
+		if payload != nil && len(ar.Payload) > 0 {
+			if err = json.Unmarshal(ar.Payload, payload); err != nil {
+				return errors.WithContext("error decoding payload: ", err)
+			}
+		}
+		return nil
+	})
+}
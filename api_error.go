@@ -0,0 +1,64 @@
+package messenger
+
+import stderrors "errors"
+
+const (
+	errCodePermission         = 10
+	errCodeTooManyCalls       = 17
+	errCodeOAuth              = 190
+	errCodePermissionDenied   = 200
+	errCodeRateLimited        = 368
+	errCodeRateLimitedApp     = 613
+	errCodeNotFound           = 803
+	errCodeNotLoggedIn        = 1357001
+	errCodeInvalidToken       = 1357004
+	errCodeContentUnavailable = 1357031
+	errCodeFeatureBlocked     = 1390008
+	errCodeCannotMessage      = 1545041
+	errCodeInvalidDocID       = 1675002
+)
+
+type APIError struct {
+	Code         int    `json:"code"`
+	APIErrorCode int    `json:"api_error_code"`
+	Summary      string `json:"summary"`
+	Description  string `json:"description"`
+	DebugInfo    string `json:"debug_info"`
+}
+
+func (a APIError) Error() string {
+	if a.Description == "" {
+		return a.Summary
+	}
+	return a.Description
+}
+
+func (a APIError) is(codes ...int) bool {
+	for _, code := range codes {
+		if a.Code == code || a.APIErrorCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+func isAPIError(err error, codes ...int) bool {
+	var a APIError
+	return stderrors.As(err, &a) && a.is(codes...)
+}
+
+func IsRateLimited(err error) bool {
+	return isAPIError(err, errCodeTooManyCalls, errCodeRateLimited, errCodeRateLimitedApp, errCodeFeatureBlocked)
+}
+
+func IsAuthError(err error) bool {
+	return isAPIError(err, errCodeOAuth, errCodeNotLoggedIn, errCodeInvalidToken)
+}
+
+func IsNotFound(err error) bool {
+	return isAPIError(err, errCodeNotFound, errCodeContentUnavailable)
+}
+
+func IsPermissionDenied(err error) bool {
+	return isAPIError(err, errCodePermission, errCodePermissionDenied, errCodeCannotMessage)
+}
@@ -0,0 +1,204 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"vimagination.zapto.org/errors"
+)
+
+const maxBatchQueries = 20
+
+type batchQuery struct {
+	desc, query string
+	params      interface{}
+	handle      func(*Client, json.RawMessage, *BatchResult) error
+}
+
+type Batch struct {
+	c       *Client
+	queries []batchQuery
+}
+
+type BatchResult struct {
+	Threads    map[string]Messages
+	Successful int
+	Errors     BatchErrors
+}
+
+type QueryError struct {
+	Query string
+	Err   error
+}
+
+func (q QueryError) Error() string {
+	return q.Query + ": " + q.Err.Error()
+}
+
+func (q QueryError) Unwrap() error {
+	return q.Err
+}
+
+type BatchErrors []QueryError
+
+func (b BatchErrors) Error() string {
+	errs := make([]string, len(b))
+	for n, err := range b {
+		errs[n] = err.Error()
+	}
+	return strings.Join(errs, "; ")
+}
+
+func (b BatchErrors) Unwrap() []error {
+	errs := make([]error, len(b))
+	for n, err := range b {
+		errs[n] = err
+	}
+	return errs
+}
+
+func (c *Client) Batch() *Batch {
+	return &Batch{c: c}
+}
+
+func (b *Batch) Thread(id string) *Batch {
+	b.queries = append(b.queries, batchQuery{
+		desc:  "Thread(" + id + ")",
+		query: queryThread,
+		params: threadParams{
+			ID:           id,
+			MessageLimit: 20,
+			LoadMessages: 1,
+		},
+		handle: func(_ *Client, data json.RawMessage, r *BatchResult) error {
+			var list messagesData
+			if err := json.Unmarshal(data, &list); err != nil {
+				return errors.WithContext("error decoding thread message list: ", err)
+			}
+			r.Threads[id] = parseMessages(list)
+			return nil
+		},
+	})
+	return b
+}
+
+func (b *Batch) ThreadList(folder Folder) *Batch {
+	b.queries = append(b.queries, batchQuery{
+		desc:  "ThreadList(" + folder.String() + ")",
+		query: queryThreadList,
+		params: threadListParams{
+			Limit:                   99,
+			Tags:                    []string{folder.tag()},
+			IncludeDeliveryReceipts: true,
+		},
+		handle: func(c *Client, data json.RawMessage, _ *BatchResult) error {
+			var list threadListData
+			if err := json.Unmarshal(data, &list); err != nil {
+				return errors.WithContext("error decoding thread list: ", err)
+			}
+			return c.parseThreadData(list, folder)
+		},
+	})
+	return b
+}
+
+func (b *Batch) Do(ctx context.Context) (*BatchResult, error) {
+	r := &BatchResult{
+		Threads: make(map[string]Messages),
+	}
+	for queries := b.queries; len(queries) > 0; {
+		n := len(queries)
+		if n > maxBatchQueries {
+			n = maxBatchQueries
+		}
+		if err := b.c.doBatch(ctx, queries[:n], r); err != nil {
+			return r, err
+		}
+		queries = queries[n:]
+	}
+	if len(r.Errors) > 0 {
+		return r, r.Errors
+	}
+	return r, nil
+}
+
+func (c *Client) doBatch(ctx context.Context, queries []batchQuery, r *BatchResult) error {
+	var results map[string]json.RawMessage
+	var failed map[string]error
+	if err := c.withRetry(ctx, true, func() error {
+		qs := make(map[string]graphQLQuery, len(queries))
+		for n, q := range queries {
+			docIDKey, ok := queryDocIDs[q.query]
+			if !ok {
+				return ErrUnknownQuery
+			}
+			qs["o"+strconv.Itoa(n)] = graphQLQuery{
+				DocID:       c.docID(docIDKey),
+				QueryParams: q.params,
+			}
+		}
+		buf, err := json.Marshal(qs)
+		if err != nil {
+			return errors.WithContext("error encoding batch queries: ", err)
+		}
+		post := make(url.Values)
+		post.Set("batch_name", queryDocIDs[queries[0].query])
+		post.Set("queries", string(buf))
+		resp, err := c.postFormContext(ctx, cAPIURL, post)
+		if err != nil {
+			return errors.WithContext("error sending batch request: ", err)
+		}
+		defer resp.Body.Close()
+		results = make(map[string]json.RawMessage, len(queries))
+		failed = make(map[string]error)
+		br := newBatchReader(resp.Body)
+		for {
+			key, data, err := br.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if err = queryError(data); err != nil {
+				if isStaleSession(err) || IsRateLimited(err) {
+					return err
+				}
+				failed[key] = err
+				continue
+			}
+			results[key] = data
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for n, q := range queries {
+		key := "o" + strconv.Itoa(n)
+		err, ok := failed[key]
+		if !ok {
+			if data, ok := results[key]; ok {
+				err = q.handle(c, data, r)
+			} else {
+				err = ErrMissingResult
+			}
+		}
+		if err != nil {
+			r.Errors = append(r.Errors, QueryError{
+				Query: q.desc,
+				Err:   err,
+			})
+		} else {
+			r.Successful++
+		}
+	}
+	return nil
+}
+
+const (
+	ErrMissingResult errors.Error = "missing query result"
+	ErrUnknownQuery  errors.Error = "unknown query"
+)
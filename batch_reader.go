@@ -0,0 +1,88 @@
+package messenger
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"vimagination.zapto.org/errors"
+)
+
+type batchObject struct {
+	Error   APIError `json:"error"`
+	Summary *int     `json:"successful_results"`
+}
+
+type queryResult struct {
+	Error  *APIError  `json:"error"`
+	Errors []APIError `json:"errors"`
+}
+
+type batchReader struct {
+	dec     *json.Decoder
+	pending []string
+	objects map[string]json.RawMessage
+	done    bool
+}
+
+func newBatchReader(r io.Reader) *batchReader {
+	br := bufio.NewReader(r)
+	if prefix, err := br.Peek(len(jsonPrefix)); err == nil && string(prefix) == jsonPrefix {
+		br.Discard(len(jsonPrefix))
+	}
+	return &batchReader{
+		dec: json.NewDecoder(br),
+	}
+}
+
+func (b *batchReader) Next() (string, json.RawMessage, error) {
+	for len(b.pending) == 0 {
+		if b.done {
+			return "", nil, io.EOF
+		}
+		var raw json.RawMessage
+		if err := b.dec.Decode(&raw); err == io.EOF {
+			b.done = true
+			return "", nil, io.EOF
+		} else if err != nil {
+			return "", nil, errors.WithContext("error decoding batch response: ", err)
+		}
+		var obj batchObject
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return "", nil, errors.WithContext("error decoding batch response: ", err)
+		}
+		if obj.Error.Code != 0 || obj.Error.APIErrorCode != 0 {
+			return "", nil, obj.Error
+		}
+		if obj.Summary != nil {
+			b.done = true
+			continue
+		}
+		b.objects = nil
+		if err := json.Unmarshal(raw, &b.objects); err != nil {
+			return "", nil, errors.WithContext("error decoding batch response: ", err)
+		}
+		for key := range b.objects {
+			b.pending = append(b.pending, key)
+		}
+		sort.Strings(b.pending)
+	}
+	key := b.pending[0]
+	b.pending = b.pending[1:]
+	return key, b.objects[key], nil
+}
+
+func queryError(data json.RawMessage) error {
+	var qr queryResult
+	if err := json.Unmarshal(data, &qr); err != nil {
+		return errors.WithContext("error decoding query result: ", err)
+	}
+	if qr.Error != nil && (qr.Error.Code != 0 || qr.Error.APIErrorCode != 0) {
+		return *qr.Error
+	}
+	if len(qr.Errors) > 0 {
+		return qr.Errors[0]
+	}
+	return nil
+}
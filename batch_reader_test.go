@@ -0,0 +1,147 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type rtFunc func(*http.Request) (*http.Response, error)
+
+func (f rtFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestBatchReader(t *testing.T) {
+	for n, test := range []struct {
+		Input     string
+		Keys      []string
+		Data      []string
+		Err       error
+		Malformed bool
+	}{
+		{},
+		{
+			Input: `for (;;);{"o0":{"data":1}}
+{"successful_results":1,"error_results":0,"skipped_results":0}`,
+			Keys: []string{"o0"},
+			Data: []string{`{"data":1}`},
+		},
+		{
+			Input: `{"o0":{"data":1}}
+{"successful_results":1,"error_results":0,"skipped_results":0}`,
+			Keys: []string{"o0"},
+			Data: []string{`{"data":1}`},
+		},
+		{
+			Input: `for (;;);{"o1":{"data":2},"o0":{"data":1}}{"o2":{"data":3}}`,
+			Keys:  []string{"o0", "o1", "o2"},
+			Data:  []string{`{"data":1}`, `{"data":2}`, `{"data":3}`},
+		},
+		{
+			Input: `for (;;);{"o0":{"data":1}}
+{"successful_results":1,"error_results":0,"skipped_results":0}
+{"o1":{"data":2}}
+not json`,
+			Keys: []string{"o0"},
+			Data: []string{`{"data":1}`},
+		},
+		{
+			Input: `for (;;);{"o0":{"errors":[{"code":803,"summary":"Not found"}]}}
+{"successful_results":0,"error_results":1,"skipped_results":0}`,
+			Keys: []string{"o0"},
+			Data: []string{`{"errors":[{"code":803,"summary":"Not found"}]}`},
+		},
+		{
+			Input: `for (;;);{"error":{"code":1357001,"summary":"Not logged in"}}`,
+			Err:   APIError{Code: 1357001, Summary: "Not logged in"},
+		},
+		{
+			Input:     `for (;;);{"o0":{"data":1}}{"o1":`,
+			Keys:      []string{"o0"},
+			Data:      []string{`{"data":1}`},
+			Malformed: true,
+		},
+	} {
+		var (
+			keys, data []string
+			err        error
+		)
+		br := newBatchReader(strings.NewReader(test.Input))
+		for {
+			var (
+				key string
+				raw json.RawMessage
+			)
+			if key, raw, err = br.Next(); err != nil {
+				break
+			}
+			keys = append(keys, key)
+			data = append(data, string(raw))
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if !reflect.DeepEqual(keys, test.Keys) || !reflect.DeepEqual(data, test.Data) {
+			t.Errorf("test %d: expecting keys %v with data %v, got %v with %v", n+1, test.Keys, test.Data, keys, data)
+		} else if test.Malformed {
+			if err == nil {
+				t.Errorf("test %d: expecting decoding error", n+1)
+			}
+		} else if err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		}
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	for n, test := range []struct {
+		Input string
+		Err   error
+	}{
+		{Input: `{"data":{}}`},
+		{Input: `{"data":{},"error":{"code":0}}`},
+		{Input: `{"error":{"code":803,"summary":"Not found"}}`, Err: APIError{Code: 803, Summary: "Not found"}},
+		{Input: `{"errors":[{"code":10,"summary":"No"},{"code":803}]}`, Err: APIError{Code: 10, Summary: "No"}},
+	} {
+		if err := queryError(json.RawMessage(test.Input)); err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		}
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	c := &Client{
+		threads: make(map[string]Thread),
+		users:   make(map[string]User),
+		docIDs:  map[string]string{queryDocIDs[queryThread]: "1"},
+	}
+	c.client.Jar = newJar()
+	c.client.Transport = rtFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body: io.NopCloser(strings.NewReader(`for (;;);{"o0":{"data":{"message_thread":{"messages":{"nodes":[{"message":{"text":"a"},"timestamp_precise":"1000"}]}}}}}
+{"o1":{"errors":[{"code":10,"summary":"Permission denied"}]}}
+{"successful_results":1,"error_results":1,"skipped_results":1}`)),
+			Request: r,
+		}, nil
+	})
+	c.SetOptions(WithRateLimit(0, 0))
+	r, err := c.Batch().Thread("5").Thread("6").Thread("7").Do(context.Background())
+	var errs BatchErrors
+	if !errors.As(err, &errs) || !reflect.DeepEqual(errs, r.Errors) {
+		t.Fatalf("expecting BatchErrors, got %v", err)
+	} else if r.Successful != 1 || len(r.Threads["5"]) != 1 || r.Threads["5"][0].Message != "a" {
+		t.Fatalf("unexpected result: %+v", r)
+	} else if len(errs) != 2 || errs[0].Query != "Thread(6)" || errs[0].Err != (APIError{Code: 10, Summary: "Permission denied"}) || errs[1].Query != "Thread(7)" || errs[1].Err != ErrMissingResult {
+		t.Fatalf("unexpected errors: %+v", errs)
+	} else if !IsPermissionDenied(err) || !errors.Is(err, ErrMissingResult) {
+		t.Fatalf("expecting wrapped query errors, got %v", err)
+	}
+}
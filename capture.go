@@ -0,0 +1,330 @@
+package messenger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vimagination.zapto.org/errors"
+)
+
+const redacted = "REDACTED"
+
+var (
+	redactHeaders = [...]string{"Authorization", "Cookie", "Proxy-Authorization"}
+	redactFields  = map[string]bool{
+		"email":          true,
+		"pass":           true,
+		"approvals_code": true,
+		"fb_dtsg":        true,
+		"lsd":            true,
+		"jazoest":        true,
+	}
+	publicCookies = map[string]bool{
+		"c_user": true,
+	}
+	redactTokens     = regexp.MustCompile(`(\\?"(?:token|fb_dtsg|dtsg_ag|async_get_token|lsd|jazoest|datr|_js_datr)\\?"\s*:\s*\\?")[^"\\]+`)
+	redactInputs     = regexp.MustCompile(`<input\b[^>]*>`)
+	inputName        = regexp.MustCompile(`\bname\s*=\s*"([^"]*)"`)
+	inputValue       = regexp.MustCompile(`(\bvalue\s*=\s*")[^"]+`)
+	redactCookieCore = regexp.MustCompile(`("CookieCore"\s*,\s*"set"\s*,\s*\[[^\]]*\]\s*,\s*\[\s*"([^"]*)"\s*,\s*")[^"]+`)
+)
+
+type Capture struct {
+	Time           time.Time   `json:"time"`
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"requestHeader,omitempty"`
+	RequestBody    string      `json:"requestBody,omitempty"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	ResponseBody   string      `json:"responseBody,omitempty"`
+	Truncated      bool        `json:"truncated,omitempty"`
+}
+
+type Recorder struct {
+	Transport http.RoundTripper
+
+	mu     sync.Mutex
+	count  int
+	write  func(n int, c *Capture) error
+	client *Client
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	enc := json.NewEncoder(w)
+	return &Recorder{
+		write: func(_ int, c *Capture) error {
+			return enc.Encode(c)
+		},
+	}
+}
+
+func NewDirRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.WithContext("error creating capture directory: ", err)
+	}
+	return &Recorder{
+		write: func(n int, c *Capture) error {
+			f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%06d.json", n)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+			if err = json.NewEncoder(f).Encode(c); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+	}, nil
+}
+
+func WithRecorder(r *Recorder) Option {
+	return func(c *Client) {
+		if r.Transport == nil {
+			r.Transport = c.client.Transport
+		}
+		c.client.Transport = r
+		r.client = c
+	}
+}
+
+func WithTransport(t http.RoundTripper) Option {
+	return func(c *Client) {
+		c.client.Transport = t
+	}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	capture := Capture{
+		Time:          time.Now(),
+		Method:        req.Method,
+		URL:           redactURL(req.URL),
+		RequestHeader: redactRequestHeader(req.Header),
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.WithContext("error reading request body: ", err)
+		}
+		capture.RequestBody = redactBody(req.Header.Get("Content-Type"), body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	t := r.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	var rb io.Reader = resp.Body
+	maxSize := r.client.getResourceLimits().MaxSize
+	if maxSize > 0 {
+		rb = io.LimitReader(rb, maxSize+1)
+	}
+	body, err := io.ReadAll(rb)
+	if err != nil {
+		resp.Body.Close()
+		return nil, errors.WithContext("error reading response body: ", err)
+	}
+	if maxSize > 0 && int64(len(body)) > maxSize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{
+			io.MultiReader(bytes.NewReader(body), resp.Body),
+			resp.Body,
+		}
+		body = body[:maxSize]
+		capture.Truncated = true
+	} else {
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	capture.Status = resp.StatusCode
+	capture.ResponseHeader = redactResponseHeader(resp.Header)
+	capture.ResponseBody = redactResponseBody(string(body))
+	r.mu.Lock()
+	r.count++
+	err = r.write(r.count, &capture)
+	r.mu.Unlock()
+	if err != nil {
+		resp.Body.Close()
+		return nil, errors.WithContext("error writing capture: ", err)
+	}
+	return resp, nil
+}
+
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	v := *u
+	query := v.Query()
+	redactValues(query)
+	v.RawQuery = query.Encode()
+	return v.String()
+}
+
+func redactValues(values url.Values) {
+	for key, vals := range values {
+		if redactFields[key] {
+			for n := range vals {
+				vals[n] = redacted
+			}
+		}
+	}
+}
+
+func redactBody(contentType string, body []byte) string {
+	if !strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return string(body)
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return string(body)
+	}
+	redactValues(values)
+	return values.Encode()
+}
+
+func redactRequestHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, key := range redactHeaders {
+		if _, ok := h[key]; ok {
+			h[key] = []string{redacted}
+		}
+	}
+	return h
+}
+
+func redactResponseHeader(h http.Header) http.Header {
+	h = h.Clone()
+	cookies := h.Values("Set-Cookie")
+	for n, cookie := range cookies {
+		name, rest, ok := strings.Cut(cookie, "=")
+		if !ok || publicCookies[name] {
+			continue
+		}
+		attrs := ""
+		if pos := strings.IndexByte(rest, ';'); pos >= 0 {
+			attrs = rest[pos:]
+		}
+		cookies[n] = name + "=" + redacted + attrs
+	}
+	return h
+}
+
+func redactResponseBody(body string) string {
+	body = redactTokens.ReplaceAllString(body, "${1}"+redacted)
+	body = redactInputs.ReplaceAllStringFunc(body, func(input string) string {
+		if name := inputName.FindStringSubmatch(input); name == nil || !redactFields[name[1]] {
+			return input
+		}
+		return inputValue.ReplaceAllString(input, "${1}"+redacted)
+	})
+	return redactCookieCore.ReplaceAllStringFunc(body, func(set string) string {
+		if publicCookies[redactCookieCore.FindStringSubmatch(set)[2]] {
+			return set
+		}
+		return redactCookieCore.ReplaceAllString(set, "${1}"+redacted)
+	})
+}
+
+type Replay struct {
+	mu       sync.Mutex
+	captures []Capture
+	used     []bool
+}
+
+func NewReplay(r io.Reader) (*Replay, error) {
+	var captures []Capture
+	dec := json.NewDecoder(r)
+	for {
+		var c Capture
+		if err := dec.Decode(&c); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.WithContext("error reading capture: ", err)
+		}
+		captures = append(captures, c)
+	}
+	return newReplay(captures), nil
+}
+
+func LoadReplayDir(dir string) (*Replay, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	captures := make([]Capture, 0, len(files))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, errors.WithContext("error opening capture: ", err)
+		}
+		var c Capture
+		err = json.NewDecoder(f).Decode(&c)
+		f.Close()
+		if err != nil {
+			return nil, errors.WithContext("error reading capture: ", err)
+		}
+		captures = append(captures, c)
+	}
+	return newReplay(captures), nil
+}
+
+func newReplay(captures []Capture) *Replay {
+	return &Replay{
+		captures: captures,
+		used:     make([]bool, len(captures)),
+	}
+}
+
+func (r *Replay) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	u := redactURL(req.URL)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for n, c := range r.captures {
+		if r.used[n] || c.Method != req.Method || c.URL != u {
+			continue
+		}
+		r.used[n] = true
+		header := c.ResponseHeader.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", c.Status, http.StatusText(c.Status)),
+			StatusCode:    c.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(c.ResponseBody)),
+			ContentLength: int64(len(c.ResponseBody)),
+			Request:       req,
+		}, nil
+	}
+	return nil, ErrNoCapture
+}
+
+const (
+	ErrNoCapture errors.Error = "no matching capture"
+)
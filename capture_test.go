@@ -0,0 +1,82 @@
+package messenger_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"vimagination.zapto.org/messenger"
+	"vimagination.zapto.org/messenger/messengertest"
+)
+
+type sniffer struct {
+	rt      http.RoundTripper
+	mu      sync.Mutex
+	secrets map[string]bool
+}
+
+func (s *sniffer) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	for _, cookie := range req.Cookies() {
+		if cookie.Name != "c_user" {
+			s.secrets[cookie.Value] = true
+		}
+	}
+	if req.GetBody != nil {
+		body, _ := req.GetBody()
+		var buf bytes.Buffer
+		buf.ReadFrom(body)
+		values, _ := url.ParseQuery(buf.String())
+		for _, field := range [...]string{"fb_dtsg", "lsd", "jazoest", "pass"} {
+			if v := values.Get(field); v != "" {
+				s.secrets[v] = true
+			}
+		}
+	}
+	s.mu.Unlock()
+	return s.rt.RoundTrip(req)
+}
+
+func TestRecordReplay(t *testing.T) {
+	s := messengertest.NewServer("me@example.com", "hunter2", messengertest.User{ID: "100", Name: "Me Self", ShortName: "Me"})
+	defer s.Close()
+	s.AddUser(messengertest.User{ID: "200", Name: "Al Bee", ShortName: "Al"})
+	s.AddThread(messengertest.Thread{ID: "200", Participants: []string{"100", "200"}, Messages: []messengertest.Message{{Sender: "200", Text: "hi"}}})
+	var buf bytes.Buffer
+	sniff := &sniffer{rt: s.Transport(), secrets: make(map[string]bool)}
+	rec := messenger.NewRecorder(&buf)
+	rec.Transport = sniff
+	c, err := messenger.Login("me@example.com", "hunter2", messenger.WithRecorder(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateThreadList(messenger.FolderInbox); err != nil {
+		t.Fatal(err)
+	}
+	if len(sniff.secrets) < 4 {
+		t.Fatalf("expected at least 4 secrets, got %d", len(sniff.secrets))
+	}
+	capture := buf.String()
+	for secret := range sniff.secrets {
+		if strings.Contains(capture, secret) {
+			t.Errorf("capture contains secret %q", secret)
+		}
+	}
+	r, err := messenger.NewReplay(strings.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = messenger.Login("me@example.com", "hunter2", messenger.WithTransport(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateThreadList(messenger.FolderInbox); err != nil {
+		t.Fatal(err)
+	}
+	if threads := c.Threads(messenger.FolderInbox); len(threads) != 1 {
+		t.Fatalf("expected 1 thread, got %d", len(threads))
+	}
+}
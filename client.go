@@ -1,9 +1,9 @@
 package messenger // import "vimagination.zapto.org/messenger"
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -13,19 +13,28 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/robertkrimen/otto"
 	"golang.org/x/net/publicsuffix"
 	xmlpath "gopkg.in/xmlpath.v2"
 	"vimagination.zapto.org/errors"
-	"vimagination.zapto.org/memio"
 )
 
 const CLIENT_VERSION = 3822019
 
 const (
-	cDomain   = "https://www.messenger.com/"
-	cLoginURL = cDomain + "login"
-	cAPIURL   = cDomain + "api/graphqlbatch"
+	cDomain    = "https://www.messenger.com/"
+	cLoginURL  = cDomain + "login"
+	cLogoutURL = cDomain + "logout/"
+	cAPIURL    = cDomain + "api/graphqlbatch"
+
+	cUserInfoURL  = cDomain + "chat/user_info/"
+	cBuddyListURL = cDomain + "ajax/chat/buddy_list.php"
+	cBlockURL     = cDomain + "messaging/block_messages/"
+	cUnblockURL   = cDomain + "messaging/unblock_messages/"
+
+	cMoveThreadURL    = cDomain + "ajax/mercury/move_thread.php"
+	cArchiveThreadURL = cDomain + "ajax/mercury/change_archived_status.php"
+	cMuteThreadURL    = cDomain + "ajax/mercury/change_mute_thread.php"
+	cDeleteThreadURL  = cDomain + "ajax/mercury/delete_thread.php"
 )
 
 var (
@@ -44,12 +53,40 @@ type Client struct {
 	postData                url.Values
 	username, usernameShort string
 	docIDs                  map[string]string
-
-	request uint64 // atomic
+	revision                string
+	docIDCache              DocIDCache
+	resourceLimits          *ResourceLimits
+	extractors              map[DataItem][]Extractor
+	noJS                    bool
+	js                      jsConfig
+	scriptStats             []ScriptStat
+	extractErrs             map[DataItem]error
+	fingerprint             Fingerprint
+
+	request   uint64 // atomic
+	loggedOut uint32 // atomic
 
 	dataMu  sync.RWMutex
 	threads map[string]Thread
 	users   map[string]User
+	synced  time.Time
+	cache   bool
+
+	checkpoint *checkpoint
+
+	limiter  tokenBucket
+	retryMu  sync.RWMutex
+	retry    *RetryPolicy
+	requests uint64 // atomic
+	retries  uint64 // atomic
+
+	refreshMu  sync.Mutex
+	sessionGen uint64 // atomic
+	onRefresh  func(error)
+
+	presenceMu   sync.Mutex
+	presenceSubs map[uint64]func(User)
+	presenceSub  uint64
 }
 
 /*
@@ -72,9 +109,13 @@ func newJar() *cookiejar.Jar {
 	return jar
 }
 
-func Login(username, password string) (*Client, error) {
+func Login(username, password string, opts ...Option) (*Client, error) {
 	var c Client
 	c.client.Jar = newJar()
+	c.SetOptions(opts...)
+	if c.js.err != nil {
+		return nil, c.js.err
+	}
 	resp, err := c.client.Get(cLoginURL)
 	if err != nil {
 		return nil, errors.WithContext("error getting login page: ", err)
@@ -84,49 +125,68 @@ func Login(username, password string) (*Client, error) {
 	if err != nil {
 		return nil, errors.WithContext("error parsing login page: ", err)
 	}
-	var (
-		cookieSet   bool
-		cookieValue string
-	)
-	if err = runCode(
-		jsFuncs{
-			"setCookieValue": func(call otto.FunctionCall) otto.Value {
-				cookieSet = true
-				cookieValue = call.Argument(0).String()
-				return otto.UndefinedValue()
-			},
-		},
-		xmlPathIter{pageScripts.Iter(nodes)},
-	); err != nil {
+	var data PageData
+	if err, ok := c.extract(newPage(&c, nodes), &data, ItemCookie)[ItemCookie]; ok {
 		return nil, errors.WithContext("error grabbing datr cookie: ", err)
 	}
-	if !cookieSet {
+	if !data.Has(ItemCookie) {
 		return nil, ErrDatrCookie
-
 	}
 	c.client.Jar.SetCookies(domain, []*http.Cookie{
 		&http.Cookie{
 			Name:     "datr",
-			Value:    cookieValue,
+			Value:    data.Cookie,
 			Path:     "/",
 			Expires:  time.Now().Add(time.Hour * 48),
 			HttpOnly: true,
 			Secure:   true,
 		},
 	})
+	postURL, inputs, err := formInputs(nodes, loginURL, "//form")
+	if err != nil {
+		return nil, errors.WithContext("error reading login form: ", err)
+	}
+	inputs.Set("email", username)
+	inputs.Set("pass", password)
+	inputs.Set("login", "1")
+	inputs.Set("persistant", "1")
+	c.client.CheckRedirect = noRedirect
+	resp, err = c.postForm(postURL, inputs)
+	if err != nil {
+		return nil, errors.WithContext("error POSTing login form: ", err)
+	}
+
+	goodCookies, err := c.loggedIn()
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	if !goodCookies {
+		return nil, c.setCheckpoint(resp)
+	}
+	resp.Body.Close()
+
+	if err = c.init(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func formInputs(nodes *xmlpath.Node, base *url.URL, form string) (string, url.Values, error) {
 	var postURL string
-	if loginURLP := xmlpath.MustCompile("//form/@action").Iter(nodes); loginURLP.Next() {
+	if loginURLP := xmlpath.MustCompile(form + "/@action").Iter(nodes); loginURLP.Next() {
 		action, err := url.Parse(loginURLP.Node().String())
 		if err != nil {
-			return nil, errors.WithContext("error parsing login URL: ", err)
+			return "", nil, errors.WithContext("error parsing form URL: ", err)
 		}
-		postURL = loginURL.ResolveReference(action).String()
+		postURL = base.ResolveReference(action).String()
 	} else {
-		return nil, errors.Error("error retrieving login POST URL")
+		return "", nil, ErrNoForm
 	}
 
 	inputs := make(url.Values)
-	for iter := xmlpath.MustCompile("//form//input/@name").Iter(nodes); iter.Next(); {
+	for iter := xmlpath.MustCompile(form + "//input/@name").Iter(nodes); iter.Next(); {
 		node := iter.Node()
 		if value := xmlpath.MustCompile(fmt.Sprintf("//input[@name=%q]/@value", node)).Iter(nodes); value.Next() {
 			inputs.Add(node.String(), value.Node().String())
@@ -134,36 +194,19 @@ func Login(username, password string) (*Client, error) {
 			inputs.Add(node.String(), "")
 		}
 	}
-	inputs.Set("email", username)
-	inputs.Set("pass", password)
-	inputs.Set("login", "1")
-	inputs.Set("persistant", "1")
-	c.client.CheckRedirect = noRedirect
-	resp, err = c.postForm(postURL, inputs)
-	if err != nil {
-		return nil, errors.WithContext("error POSTing login form: ", err)
-	}
+	return postURL, inputs, nil
+}
 
-	var goodCookies bool
+func (c *Client) loggedIn() (bool, error) {
 	for _, cookie := range c.client.Jar.Cookies(domain) {
 		if cookie.Name == "c_user" {
-			_, err = strconv.ParseUint(cookie.Value, 10, 64)
-			if err != nil {
-				return nil, errors.WithContext("error parsing userID:", err)
+			if _, err := strconv.ParseUint(cookie.Value, 10, 64); err != nil {
+				return false, errors.WithContext("error parsing userID:", err)
 			}
-			goodCookies = true
-			break
+			return true, nil
 		}
 	}
-
-	if !goodCookies {
-		return nil, ErrInvalidLogin
-	}
-
-	if err = c.init(); err != nil {
-		return nil, err
-	}
-	return &c, nil
+	return false, nil
 }
 
 func noRedirect(_ *http.Request, _ []*http.Request) error {
@@ -181,147 +224,130 @@ func (c *Client) init() error {
 		return errors.WithContext("error parsing init page: ", err)
 	}
 
-	var (
-		udSet, dtsgSet, sdSet, snSet bool
-		sprinkleName, sprinkleValue  string
-		highestBit                   int64
-	)
-	bitmap := make(map[int64]struct{})
-	resources := make(map[string][]string)
-
-	c.postData = make(url.Values)
-	c.postData.Set("__a", "1")
-	c.postData.Set("__rev", strconv.FormatUint(CLIENT_VERSION, 10))
-	var list threadList
-	if err = runCode(
-		jsFuncs{
-			"setUserData": func(call otto.FunctionCall) otto.Value {
-				c.postData.Set("__user", call.Argument(0).String())
-				c.username = call.Argument(1).String()
-				c.usernameShort = call.Argument(2).String()
-				udSet = true
-				return otto.UndefinedValue()
-			},
-			"setDTSGToken": func(call otto.FunctionCall) otto.Value {
-				sprinkleValue = call.Argument(0).String()
-				c.postData.Set("fb_dtsg", sprinkleValue)
-				dtsgSet = true
-				return otto.UndefinedValue()
-			},
-			"setSiteData": func(call otto.FunctionCall) otto.Value {
-				c.postData.Set(call.Argument(1).String(), call.Argument(2).String())
-				c.postData.Set(call.Argument(3).String(), call.Argument(4).String())
-				sdSet = true
-				return otto.UndefinedValue()
-			},
-			"setSprinkleName": func(call otto.FunctionCall) otto.Value {
-				sprinkleName = call.Argument(0).String()
-				snSet = true
-				return otto.UndefinedValue()
-			},
-			"setBitmap": func(call otto.FunctionCall) otto.Value {
-				i, _ := call.Argument(0).ToInteger()
-				bitmap[i] = struct{}{}
-				if i > highestBit {
-					highestBit = i
-				}
-				return otto.UndefinedValue()
-			},
-			"setResource": func(call otto.FunctionCall) otto.Value {
-				key := call.Argument(0).String()
-				resources[key] = append(resources[key], call.Argument(1).String())
-				return otto.UndefinedValue()
-			},
-			"setThreadData": func(call otto.FunctionCall) otto.Value {
-				json.NewDecoder(strings.NewReader(call.Argument(0).String())).Decode(&list)
-				return otto.UndefinedValue()
-			},
-		},
-		xmlPathIter{pageScripts.Iter(nodes)},
-	); err != nil {
-		return errors.WithContext("error getting init values: ", err)
+	page := newPage(c, nodes)
+	c.resetScriptStats()
+	var data PageData
+	errs := c.extract(page, &data, ItemUserData, ItemDTSG, ItemSiteData, ItemSprinkle, ItemBitmap, ItemThreadData, ItemResources, ItemLSD)
+	for _, item := range [...]DataItem{ItemUserData, ItemDTSG, ItemSiteData, ItemSprinkle} {
+		if err, ok := errs[item]; ok {
+			return errors.WithContext("error getting init values: ", err)
+		}
 	}
 
-	if !udSet {
+	if !data.Has(ItemUserData) {
 		err = ErrUnsetUserData
-	} else if !dtsgSet {
+	} else if !data.Has(ItemDTSG) {
 		err = ErrUnsetDTSGToken
-	} else if !sdSet {
+	} else if !data.Has(ItemSiteData) {
 		err = ErrUnsetSiteData
-	} else if !snSet || sprinkleName == "" {
+	} else if !data.Has(ItemSprinkle) {
 		err = ErrUnsetSprinkleName
 	}
 	if err != nil {
 		return errors.WithContext("error getting init config: ", err)
 	}
 
-	c.threads = make(map[string]Thread, len(list.List.Data.Viewer.MessageThreads.Nodes))
-	c.users = make(map[string]User)
-	if err = c.parseThreadData(list); err != nil {
+	var list threadListData
+	if data.Has(ItemThreadData) {
+		if err := json.Unmarshal(data.ThreadData, &list.Data); err != nil {
+			errs[ItemThreadData] = errors.WithContext("error decoding thread data: ", err)
+		}
+	}
+
+	c.dataMu.Lock()
+	if c.threads == nil {
+		c.threads = make(map[string]Thread, len(list.Data.Viewer.MessageThreads.Nodes))
+	}
+	if c.users == nil {
+		c.users = make(map[string]User)
+	}
+	c.dataMu.Unlock()
+	if err = c.parseThreadData(list, FolderInbox); err != nil {
 		return err
 	}
 
-	buf := make(memio.Buffer, 1, 3*len(sprinkleValue)+1)
-	buf[0] = '2'
-	for _, char := range sprinkleValue {
-		fmt.Fprint(&buf, char)
-	}
-	c.postData.Set(sprinkleName, string(buf))
-
-	_, set := bitmap[0]
-	r := newRLE(set)
-	for i := int64(1); i < highestBit; i++ {
-		_, set = bitmap[i]
-		r.WriteBool(set)
-	}
-	c.postData.Set("__dyn", r.String())
-
-	loaded := make(map[string]struct{})
-	si := make(stringIter, 0, 100)
-	var sb strings.Builder
-
-	for _, resource := range resources {
-		for _, url := range resource {
-			if _, ok := loaded[url]; !ok {
-				resp, err := c.client.Get(url)
-				if err != nil {
-					return errors.WithContext("error getting resource: ", err)
-				}
-				_, err = io.Copy(&sb, resp.Body)
-				resp.Body.Close()
-				if err != nil {
-					return errors.WithContext("error reading resource: ", err)
-				}
-				si = append(si, sb.String())
-				sb.Reset()
-				loaded[url] = struct{}{}
-			}
-		}
+	postData := make(url.Values)
+	postData.Set("__a", "1")
+	postData.Set("__rev", strconv.FormatUint(CLIENT_VERSION, 10))
+	postData.Set("__user", data.UserID)
+	postData.Set("fb_dtsg", data.DTSG)
+	for key := range data.SiteData {
+		postData.Set(key, data.SiteData.Get(key))
 	}
 
-	c.docIDs = make(map[string]string, len(resources))
+	data.DocIDs = c.cachedDocIDs(data.Revision)
+	if !data.Has(ItemDocIDs) {
+		if err, ok := c.extract(page, &data, ItemDocIDs)[ItemDocIDs]; ok {
+			return err
+		}
+		c.storeDocIDs(data.Revision, data.DocIDs)
+	}
 
-	if err = runCode(
-		jsFuncs{
-			"setID": func(call otto.FunctionCall) otto.Value {
-				c.docIDs[call.Argument(0).String()] = call.Argument(1).String()
-				return otto.UndefinedValue()
-			},
-		},
-		&si,
-	); err != nil {
-		return errors.WithContext("error running resource scripts: ", err)
+	fingerprint := newFingerprint(&data)
+	for _, script := range page.fetched {
+		fingerprint.loadedResource(script)
 	}
 
+	c.dataMu.Lock()
+	c.fingerprint = fingerprint
+	c.extractErrs = errs
+	c.postData = postData
+	c.docIDs = data.DocIDs
+	c.revision = data.Revision
+	c.username = data.Name
+	c.usernameShort = data.ShortName
+	c.dataMu.Unlock()
 	return nil
 }
 
 func (c *Client) postForm(url string, data url.Values) (*http.Response, error) {
+	return c.postFormContext(context.Background(), url, data)
+}
+
+func (c *Client) postFormContext(ctx context.Context, postURL string, data url.Values) (*http.Response, error) {
+	if atomic.LoadUint32(&c.loggedOut) == 1 {
+		return nil, ErrLoggedOut
+	}
+	c.dataMu.RLock()
+	post := make(url.Values, len(data)+len(c.postData))
+	for key, values := range data {
+		post[key] = values
+	}
 	for key := range c.postData {
-		data.Set(key, c.postData.Get(key))
+		post.Set(key, c.postData.Get(key))
+	}
+	c.fingerprint.setValues(post)
+	c.dataMu.RUnlock()
+	post.Set("__req", strconv.FormatUint(atomic.AddUint64(&c.request, 1), 36))
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, err
 	}
-	data.Set("__req", strconv.FormatUint(atomic.AddUint64(&c.request, 1), 36))
-	return c.client.PostForm(url, data)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, postURL, strings.NewReader(post.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	atomic.AddUint64(&c.requests, 1)
+	return checkTransient(c.client.Do(req))
+}
+
+func (c *Client) Logout(ctx context.Context) error {
+	resp, err := c.postFormContext(ctx, cLogoutURL, make(url.Values))
+	if err == nil {
+		resp.Body.Close()
+	}
+	c.dataMu.Lock()
+	atomic.StoreUint32(&c.loggedOut, 1)
+	c.client.Jar = newJar()
+	c.postData = nil
+	c.docIDs = nil
+	c.fingerprint = Fingerprint{}
+	c.checkpoint = nil
+	c.dataMu.Unlock()
+	if err != nil {
+		return errors.WithContext("error logging out: ", err)
+	}
+	return nil
 }
 
 const (
@@ -332,4 +358,6 @@ const (
 	ErrUnsetDTSGToken    errors.Error = "DTSG token not set"
 	ErrUnsetSiteData     errors.Error = "site data not set"
 	ErrUnsetSprinkleName errors.Error = "sprinkle name not set"
+	ErrNoForm            errors.Error = "error retrieving form POST URL"
+	ErrLoggedOut         errors.Error = "client logged out"
 )
@@ -0,0 +1,138 @@
+package messenger
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	xmlpath "gopkg.in/xmlpath.v2"
+	"vimagination.zapto.org/errors"
+)
+
+const (
+	maxRedirects       = 5
+	maxCheckpointSteps = 5
+	checkpointForm     = "//form[.//input[@name='approvals_code']]"
+	checkpointNextForm = "//form[.//*[contains(@name, 'submit[')]]"
+)
+
+type checkpoint struct {
+	url    string
+	inputs url.Values
+}
+
+func (c *Client) followRedirects(resp *http.Response) (*xmlpath.Node, *url.URL, error) {
+	for i := 0; ; i++ {
+		loc, err := resp.Location()
+		if err == http.ErrNoLocation {
+			break
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, errors.WithContext("error reading redirect: ", err)
+		}
+		if i == maxRedirects {
+			return nil, nil, ErrTooManyRedirects
+		}
+		if resp, err = c.client.Get(loc.String()); err != nil {
+			return nil, nil, errors.WithContext("error following redirect: ", err)
+		}
+	}
+	nodes, err := xmlpath.ParseHTML(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, errors.WithContext("error parsing page: ", err)
+	}
+	return nodes, resp.Request.URL, nil
+}
+
+func (c *Client) setCheckpoint(resp *http.Response) error {
+	if loc, err := resp.Location(); err != nil || !strings.Contains(loc.Path, "checkpoint") {
+		resp.Body.Close()
+		return ErrInvalidLogin
+	}
+	nodes, pageURL, err := c.followRedirects(resp)
+	if err != nil {
+		return errors.WithContext("error getting checkpoint page: ", err)
+	}
+	postURL, inputs, err := formInputs(nodes, pageURL, checkpointForm)
+	if err == ErrNoForm {
+		return ErrCheckpoint
+	} else if err != nil {
+		return errors.WithContext("error reading checkpoint form: ", err)
+	}
+	c.checkpoint = &checkpoint{
+		url:    postURL,
+		inputs: inputs,
+	}
+	return &TwoFactorError{client: c}
+}
+
+type TwoFactorError struct {
+	client *Client
+}
+
+func (t *TwoFactorError) Error() string {
+	return ErrNeedsTwoFactor.Error()
+}
+
+func (t *TwoFactorError) Is(target error) bool {
+	return target == ErrNeedsTwoFactor
+}
+
+func (t *TwoFactorError) Submit(code string) (*Client, error) {
+	if err := t.client.SubmitTwoFactor(code); err != nil {
+		return nil, err
+	}
+	return t.client, nil
+}
+
+func (c *Client) SubmitTwoFactor(code string) error {
+	if c.checkpoint == nil {
+		return ErrNoCheckpoint
+	}
+	c.checkpoint.inputs.Set("approvals_code", code)
+	c.checkpoint.inputs.Set("submit[Continue]", "Continue")
+	postURL, inputs := c.checkpoint.url, c.checkpoint.inputs
+	for step := 0; step < maxCheckpointSteps; step++ {
+		resp, err := c.postForm(postURL, inputs)
+		if err != nil {
+			return errors.WithContext("error POSTing checkpoint form: ", err)
+		}
+		nodes, pageURL, err := c.followRedirects(resp)
+		if err != nil {
+			return errors.WithContext("error getting checkpoint page: ", err)
+		}
+		loggedIn, err := c.loggedIn()
+		if err != nil {
+			return err
+		} else if loggedIn {
+			c.checkpoint = nil
+			return c.init()
+		}
+		if step == 0 && xmlpath.MustCompile(checkpointForm).Exists(nodes) {
+			if postURL, inputs, err = formInputs(nodes, pageURL, checkpointForm); err != nil {
+				return errors.WithContext("error reading checkpoint form: ", err)
+			}
+			c.checkpoint.url = postURL
+			c.checkpoint.inputs = inputs
+			return ErrInvalidTwoFactor
+		}
+		if postURL, inputs, err = formInputs(nodes, pageURL, checkpointNextForm); err != nil {
+			return ErrCheckpoint
+		}
+		if _, ok := inputs["name_action_selected"]; ok {
+			inputs.Set("name_action_selected", "save_device")
+		}
+		inputs.Set("submit[Continue]", "Continue")
+	}
+	return ErrCheckpoint
+}
+
+const (
+	ErrNeedsTwoFactor   errors.Error = "two-factor authentication required"
+	ErrInvalidTwoFactor errors.Error = "invalid two-factor authentication code"
+	ErrCheckpoint       errors.Error = "login blocked by security checkpoint"
+	ErrNoCheckpoint     errors.Error = "no pending checkpoint"
+	ErrTooManyRedirects errors.Error = "too many redirects"
+)
@@ -0,0 +1,116 @@
+package messenger
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"vimagination.zapto.org/errors"
+)
+
+type buddyList struct {
+	BuddyList struct {
+		NowAvailableList map[string]struct {
+			Active int `json:"a"`
+		} `json:"nowAvailableList"`
+		LastActiveTimes map[string]int64 `json:"last_active_times"`
+	} `json:"buddy_list"`
+}
+
+const presenceActive = 2
+
+func (c *Client) Presence(ids ...string) (map[string]User, error) {
+	post := make(url.Values)
+	post.Set("user", c.userID())
+	post.Set("fetch_mobile", "false")
+	post.Set("get_now_available_list", "true")
+	var list buddyList
+	if err := c.readAJAX(cBuddyListURL, post, &list); err != nil {
+		return nil, errors.WithContext("error getting presence: ", err)
+	}
+	if len(ids) == 0 {
+		for id := range list.BuddyList.LastActiveTimes {
+			ids = append(ids, id)
+		}
+		for id := range list.BuddyList.NowAvailableList {
+			if _, ok := list.BuddyList.LastActiveTimes[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	users := make(map[string]User, len(ids))
+	var changed []User
+	c.dataMu.Lock()
+	for _, id := range ids {
+		u, known := c.users[id]
+		if !known {
+			u.ID = id
+		}
+		available, isAvailable := list.BuddyList.NowAvailableList[id]
+		lastActive, hasLastActive := list.BuddyList.LastActiveTimes[id]
+		if !isAvailable && !hasLastActive {
+			if known {
+				users[id] = u
+			}
+			continue
+		}
+		prev := u
+		u.Active = isAvailable && available.Active == presenceActive
+		if hasLastActive {
+			u.LastActive = time.Unix(lastActive, 0).In(time.Local)
+		}
+		if !known || u.Active != prev.Active || !u.LastActive.Equal(prev.LastActive) {
+			changed = append(changed, u)
+		}
+		c.users[id] = u
+		users[id] = u
+	}
+	c.dataMu.Unlock()
+	for _, u := range changed {
+		c.notifyPresence(u)
+	}
+	return users, nil
+}
+
+func (c *Client) WatchPresence(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.Presence(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) SubscribePresence(fn func(User)) func() {
+	c.presenceMu.Lock()
+	if c.presenceSubs == nil {
+		c.presenceSubs = make(map[uint64]func(User))
+	}
+	id := c.presenceSub
+	c.presenceSub++
+	c.presenceSubs[id] = fn
+	c.presenceMu.Unlock()
+	return func() {
+		c.presenceMu.Lock()
+		delete(c.presenceSubs, id)
+		c.presenceMu.Unlock()
+	}
+}
+
+func (c *Client) notifyPresence(u User) {
+	c.presenceMu.Lock()
+	subs := make([]func(User), 0, len(c.presenceSubs))
+	for _, fn := range c.presenceSubs {
+		subs = append(subs, fn)
+	}
+	c.presenceMu.Unlock()
+	for _, fn := range subs {
+		fn(u)
+	}
+}
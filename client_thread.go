@@ -0,0 +1,97 @@
+package messenger
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"vimagination.zapto.org/errors"
+)
+
+var MuteForever = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+func muteToTime(until int64) time.Time {
+	switch {
+	case until < 0:
+		return MuteForever
+	case until == 0:
+		return time.Time{}
+	default:
+		return time.Unix(until, 0).In(time.Local)
+	}
+}
+
+func (c *Client) ArchiveThread(id string) error {
+	return c.setArchived(id, true)
+}
+
+func (c *Client) UnarchiveThread(id string) error {
+	return c.setArchived(id, false)
+}
+
+func (c *Client) setArchived(id string, archived bool) error {
+	post := make(url.Values)
+	post.Set("ids["+id+"]", strconv.FormatBool(archived))
+	if err := c.postAJAX(cArchiveThreadURL, post, nil); err != nil {
+		return errors.WithContext("error changing archived status: ", err)
+	}
+	c.dataMu.Lock()
+	if thread, ok := c.threads[id]; ok {
+		thread.Archived = archived
+		if archived {
+			thread.Folder = FolderArchived
+		} else if thread.Folder == FolderArchived {
+			thread.Folder = FolderInbox
+		}
+		c.threads[id] = thread
+	}
+	c.dataMu.Unlock()
+	return nil
+}
+
+func (c *Client) MuteThread(id string, until time.Time) error {
+	settings := int64(-1)
+	if !until.Equal(MuteForever) {
+		settings = int64(time.Until(until) / time.Second)
+		if settings <= 0 {
+			return ErrInvalidMuteTime
+		}
+	}
+	return c.setMute(id, settings, until)
+}
+
+func (c *Client) UnmuteThread(id string) error {
+	return c.setMute(id, 0, time.Time{})
+}
+
+func (c *Client) setMute(id string, settings int64, until time.Time) error {
+	post := make(url.Values)
+	post.Set("thread_fbid", id)
+	post.Set("mute_settings", strconv.FormatInt(settings, 10))
+	if err := c.postAJAX(cMuteThreadURL, post, nil); err != nil {
+		return errors.WithContext("error changing mute status: ", err)
+	}
+	c.dataMu.Lock()
+	if thread, ok := c.threads[id]; ok {
+		thread.MutedUntil = until
+		c.threads[id] = thread
+	}
+	c.dataMu.Unlock()
+	return nil
+}
+
+func (c *Client) DeleteThread(id string) error {
+	post := make(url.Values)
+	post.Set("ids[0]", id)
+	if err := c.postAJAX(cDeleteThreadURL, post, nil); err != nil {
+		return errors.WithContext("error deleting thread: ", err)
+	}
+	c.dataMu.Lock()
+	delete(c.threads, id)
+	c.dataMu.Unlock()
+	return nil
+}
+
+const (
+	ErrInvalidMuteTime errors.Error = "mute time must be in the future"
+)
@@ -1,11 +1,11 @@
 package messenger
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"net/url"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"vimagination.zapto.org/errors"
@@ -40,94 +40,137 @@ func getThreadType(t string) ThreadType {
 	}
 }
 
-type apiError struct {
-	Code         int    `json:"code"`
-	APIErrorCode int    `json:"api_error_code"`
-	Summary      string `json:"summary"`
-	Description  string `json:"description"`
-	DebugInfo    string `json:"debug_info"`
+type Folder int
+
+const (
+	FolderInbox Folder = iota
+	FolderPending
+	FolderOther
+	FolderArchived
+)
+
+func (f Folder) String() string {
+	switch f {
+	case FolderInbox:
+		return "Inbox"
+	case FolderPending:
+		return "Pending"
+	case FolderOther:
+		return "Other"
+	case FolderArchived:
+		return "Archived"
+	default:
+		return "Unknown"
+	}
 }
 
-func (a apiError) Error() string {
-	return a.Description
+func (f Folder) tag() string {
+	switch f {
+	case FolderPending:
+		return "PENDING"
+	case FolderOther:
+		return "OTHER"
+	case FolderArchived:
+		return "ARCHIVED"
+	default:
+		return "INBOX"
+	}
 }
 
-type threadList struct {
-	List struct {
-		Data struct {
-			Viewer struct {
-				MessageThreads struct {
-					Nodes []struct {
-						ThreadKey struct {
-							ThreadFBID  string `json:"thread_fbid"`
-							OtherUserID string `json:"other_user_id"`
-						} `json:"thread_key"`
-						Name        string `json:"name"`
-						LastMessage struct {
-							Nodes []struct {
-								Snippet       string `json:"snippet"`
-								MessageSender struct {
-									MessagingActor struct {
-										ID string `json:"id"`
-									} `json:"messaging_actor"`
-								} `json:"message_sender"`
-								Timestamp string `json:"timestamp_precise"`
-							} `json:"nodes"`
-						} `json:"last_message"`
-						UnreadCount   int    `json:"unread_count"`
-						MessagesCount int    `json:"messages_count"`
-						UpdatedTime   string `json:"updated_time_precise"`
-						Customisation struct {
-							Participants []struct {
-								ID       string `json:"participant_id"`
-								Nickname string `json:"nickname"`
-							} `json:"participant_customizations"`
-						} `json:"customization_info"`
-						LastReadReceipt struct {
-							Nodes []struct {
-								Timestamp string `json:"timestamp_precise"`
-							} `json:"nodes"`
-						} `json:"last_read_receipt"`
-						ThreadType   string `json:"thread_type"`
-						Participants struct {
-							Nodes []struct {
+func getFolder(f string, def Folder) Folder {
+	switch f {
+	case "INBOX":
+		return FolderInbox
+	case "PENDING":
+		return FolderPending
+	case "OTHER":
+		return FolderOther
+	case "ARCHIVED":
+		return FolderArchived
+	default:
+		return def
+	}
+}
+
+type threadListData struct {
+	Data struct {
+		Viewer struct {
+			MessageThreads struct {
+				Nodes []struct {
+					ThreadKey struct {
+						ThreadFBID  string `json:"thread_fbid"`
+						OtherUserID string `json:"other_user_id"`
+					} `json:"thread_key"`
+					Name        string `json:"name"`
+					Folder      string `json:"folder"`
+					MuteUntil   *int64 `json:"mute_until"`
+					LastMessage struct {
+						Nodes []struct {
+							Snippet       string `json:"snippet"`
+							MessageSender struct {
 								MessagingActor struct {
-									ID        string `json:"id"`
-									Type      string `json:"__typename"`
-									Name      string `json:"name"`
-									Gender    string `json:"gender"`
-									URL       string `json:"url"`
-									ShortName string `json:"short_name"`
-									Username  string `json:"username"`
-								} `json:"messaging_actor"`
-							} `json:"nodes"`
-						} `json:"all_participants"`
-						ReadReceipts struct {
-							Nodes []struct {
-								Watermark string `json:"watermark"`
-								Action    string `json:"action"`
-								Actor     struct {
 									ID string `json:"id"`
-								} `json:"actor"`
-							} `json:"nodes"`
-						} `json:"read_receipts"`
-						DeliveryReceipts struct {
-							Nodes []struct {
-								Timestamp string `json:"timestamp_precise"`
-							} `json:"nodes"`
-						} `json:"delivery_receipts"`
-					} `json:"nodes"`
-				} `json:"message_threads"`
-			} `json:"viewer"`
-		} `json:"data"`
-	} `json:"o0"`
-	Error apiError `json:"error"`
+								} `json:"messaging_actor"`
+							} `json:"message_sender"`
+							Timestamp string `json:"timestamp_precise"`
+						} `json:"nodes"`
+					} `json:"last_message"`
+					UnreadCount   int    `json:"unread_count"`
+					MessagesCount int    `json:"messages_count"`
+					UpdatedTime   string `json:"updated_time_precise"`
+					Customisation struct {
+						Participants []struct {
+							ID       string `json:"participant_id"`
+							Nickname string `json:"nickname"`
+						} `json:"participant_customizations"`
+					} `json:"customization_info"`
+					LastReadReceipt struct {
+						Nodes []struct {
+							Timestamp string `json:"timestamp_precise"`
+						} `json:"nodes"`
+					} `json:"last_read_receipt"`
+					ThreadType   string `json:"thread_type"`
+					Participants struct {
+						Nodes []struct {
+							MessagingActor struct {
+								ID        string `json:"id"`
+								Type      string `json:"__typename"`
+								Name      string `json:"name"`
+								Gender    string `json:"gender"`
+								URL       string `json:"url"`
+								ShortName string `json:"short_name"`
+								Username  string `json:"username"`
+								Blocked   *bool  `json:"is_message_blocked_by_viewer"`
+							} `json:"messaging_actor"`
+						} `json:"nodes"`
+					} `json:"all_participants"`
+					ReadReceipts struct {
+						Nodes []struct {
+							Watermark string `json:"watermark"`
+							Action    string `json:"action"`
+							Actor     struct {
+								ID string `json:"id"`
+							} `json:"actor"`
+						} `json:"nodes"`
+					} `json:"read_receipts"`
+					DeliveryReceipts struct {
+						Nodes []struct {
+							Timestamp string `json:"timestamp_precise"`
+						} `json:"nodes"`
+					} `json:"delivery_receipts"`
+				} `json:"nodes"`
+			} `json:"message_threads"`
+		} `json:"viewer"`
+	} `json:"data"`
 }
 
 type Thread struct {
 	ID                        string
 	Name                      string
 	Type                      ThreadType
+	Folder                    Folder
+	Archived                  bool
+	MutedUntil                time.Time
 	Participants              []string
 	ParticipantCustomisation  map[string]string
 	UnreadCount, MessageCount int
@@ -139,40 +182,46 @@ type Thread struct {
 	}
 }
 
-func (c *Client) UpdateThreadList() error {
-	post := make(url.Values)
-	post.Set("batch_name", "MessengerGraphQLThreadlistFetcher")
-	post.Set("queries", fmt.Sprintf("{\"o0\":{\"doc_id\":%q,\"query_params\":{\"limit\":99,\"before\":null,\"tags\":[],\"isWorkUser\":0,\"includeDeliveryReceipts\":true,\"includeSeqID\":false}}}", c.docIDs["MessengerGraphQLThreadlistFetcher"]))
-
-	resp, err := c.postForm(cAPIURL, post)
-	if err != nil {
-		return errors.WithContext("error getting thread list: ", err)
+func (t Thread) clone() Thread {
+	t.Participants = append([]string(nil), t.Participants...)
+	customisation := make(map[string]string, len(t.ParticipantCustomisation))
+	for id, nickname := range t.ParticipantCustomisation {
+		customisation[id] = nickname
 	}
-	var list threadList
-	err = json.NewDecoder(resp.Body).Decode(&list)
-	resp.Body.Close()
-	if err != nil {
-		return errors.WithContext("error decoding thread list: ", err)
+	t.ParticipantCustomisation = customisation
+	return t
+}
+
+func (c *Client) UpdateThreadList(folders ...Folder) error {
+	if len(folders) == 0 {
+		folders = []Folder{FolderInbox}
 	}
-	if list.Error.APIErrorCode != 0 {
-		return list.Error
+	b := c.Batch()
+	for _, folder := range folders {
+		b.ThreadList(folder)
 	}
-	return c.parseThreadData(list)
+	_, err := b.Do(context.Background())
+	return err
 }
 
-func (c *Client) parseThreadData(list threadList) error {
+func (c *Client) parseThreadData(list threadListData, folder Folder) error {
 	c.dataMu.Lock()
-	for _, node := range list.List.Data.Viewer.MessageThreads.Nodes {
+	for _, node := range list.Data.Viewer.MessageThreads.Nodes {
 		thread := Thread{
 			ID:                       node.ThreadKey.ThreadFBID,
 			Name:                     node.Name,
 			Type:                     getThreadType(node.ThreadType),
+			Folder:                   getFolder(node.Folder, folder),
 			Participants:             make([]string, 0, len(node.Participants.Nodes)),
 			ParticipantCustomisation: make(map[string]string, len(node.Customisation.Participants)),
 			UnreadCount:              node.UnreadCount,
 			MessageCount:             node.MessagesCount,
 			Updated:                  unixToTime(node.UpdatedTime),
 		}
+		thread.Archived = thread.Folder == FolderArchived
+		if node.MuteUntil != nil {
+			thread.MutedUntil = muteToTime(*node.MuteUntil)
+		}
 		if len(node.LastMessage.Nodes) > 0 {
 			lm := node.LastMessage.Nodes[0]
 			thread.LastMessage.Sender = lm.MessageSender.MessagingActor.ID
@@ -187,6 +236,11 @@ func (c *Client) parseThreadData(list threadList) error {
 				Username:  user.MessagingActor.Username,
 				Gender:    getGender(user.MessagingActor.Gender),
 			})
+			if blocked := user.MessagingActor.Blocked; blocked != nil {
+				u := c.users[user.MessagingActor.ID]
+				u.Blocked = *blocked
+				c.users[user.MessagingActor.ID] = u
+			}
 			thread.Participants = append(thread.Participants, user.MessagingActor.ID)
 		}
 		if thread.Type == ThreadOneToOne {
@@ -198,47 +252,90 @@ func (c *Client) parseThreadData(list threadList) error {
 		}
 		c.threads[thread.ID] = thread
 	}
+	c.synced = time.Now()
+	c.dataMu.Unlock()
+	return nil
+}
+
+func (c *Client) Threads(folder Folder) []Thread {
+	c.dataMu.RLock()
+	threads := make([]Thread, 0, len(c.threads))
+	for _, thread := range c.threads {
+		if thread.Folder == folder {
+			threads = append(threads, thread.clone())
+		}
+	}
+	c.dataMu.RUnlock()
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].Updated.After(threads[j].Updated)
+	})
+	return threads
+}
+
+func (c *Client) LastSync() time.Time {
+	c.dataMu.RLock()
+	synced := c.synced
+	c.dataMu.RUnlock()
+	return synced
+}
+
+func (c *Client) AcceptMessageRequest(id string) error {
+	return c.moveThread(id, FolderInbox)
+}
+
+func (c *Client) IgnoreMessageRequest(id string) error {
+	return c.moveThread(id, FolderOther)
+}
+
+func (c *Client) moveThread(id string, folder Folder) error {
+	post := make(url.Values)
+	post.Set(strings.ToLower(folder.tag())+"[0]", id)
+	if err := c.postAJAX(cMoveThreadURL, post, nil); err != nil {
+		return errors.WithContext("error moving thread: ", err)
+	}
+	c.dataMu.Lock()
+	if thread, ok := c.threads[id]; ok {
+		thread.Folder = folder
+		c.threads[id] = thread
+	}
 	c.dataMu.Unlock()
 	return nil
 }
 
-type messages struct {
-	List struct {
-		Data struct {
-			MessageThread struct {
-				UnreadCount  int    `json:"unread_count"`
-				MessageCount int    `json:"message_count"`
-				UpdatedTime  string `json:"updated_time_precise"`
-				Messages     struct {
-					PageInfo struct {
-						HasPreviousPage bool `json:"has_previous_page"`
-					} `json:"page_info"`
-					Nodes []struct {
-						TypeName string `json:"__typename"`
-						Sender   struct {
-							ID    string `json:"id"`
-							Email string `json:"email"`
-						} `json:"message_sender"`
-						Timestamp string `json:"timestamp_precise"`
-						Unread    bool   `json:"unread"`
-						Message   struct {
-							Text string `json:"text"`
-						} `json:"message"`
-						EMAdminText struct {
-							TypeName    string `json:"__typename"`       // ADD_CONTACT, ACCEPT_PENDING_THREAD
-							AddedID     string `json:"contact_added_id"` // Message request of...
-							AdderID     string `json:"contact_adder_id"` // Message request by...
-							AccepterID  string `json:"accepter_id"`      // Message accepted by...
-							RequesterID string `json:"requester_id"`     // Message accepted of...
-						} `json:"extensible_message_admin_text"`
-						EMAdminTextType string `json:"extensible_message_admin_text_type"`
-						Snippet         string `json:"snippet"`
-					} `json:"nodes"`
-				} `json:"messages"`
-			} `json:"message_thread"`
-		} `json:"data"`
-	} `json:"o0"`
-	Error apiError `json:"error"`
+type messagesData struct {
+	Data struct {
+		MessageThread struct {
+			UnreadCount  int    `json:"unread_count"`
+			MessageCount int    `json:"message_count"`
+			UpdatedTime  string `json:"updated_time_precise"`
+			Messages     struct {
+				PageInfo struct {
+					HasPreviousPage bool `json:"has_previous_page"`
+				} `json:"page_info"`
+				Nodes []struct {
+					TypeName string `json:"__typename"`
+					Sender   struct {
+						ID    string `json:"id"`
+						Email string `json:"email"`
+					} `json:"message_sender"`
+					Timestamp string `json:"timestamp_precise"`
+					Unread    bool   `json:"unread"`
+					Message   struct {
+						Text string `json:"text"`
+					} `json:"message"`
+					EMAdminText struct {
+						TypeName    string `json:"__typename"`       // ADD_CONTACT, ACCEPT_PENDING_THREAD
+						AddedID     string `json:"contact_added_id"` // Message request of...
+						AdderID     string `json:"contact_adder_id"` // Message request by...
+						AccepterID  string `json:"accepter_id"`      // Message accepted by...
+						RequesterID string `json:"requester_id"`     // Message accepted of...
+					} `json:"extensible_message_admin_text"`
+					EMAdminTextType string `json:"extensible_message_admin_text_type"`
+					Snippet         string `json:"snippet"`
+				} `json:"nodes"`
+			} `json:"messages"`
+		} `json:"message_thread"`
+	} `json:"data"`
 }
 
 type Message struct {
@@ -261,23 +358,16 @@ func (m Messages) Swap(i, j int) {
 }
 
 func (c *Client) GetThread(id string) (Messages, error) {
-	post := make(url.Values)
-	post.Set("batch_name", "MessengerGraphQLThreadFetcher")
-	post.Set("queries", fmt.Sprintf("{\"o0\":{\"doc_id\":%q,\"query_params\":{\"id\":%q,\"message_limit\":20,\"load_messages\":1,\"load_read_receipts\":false,\"before\":null}}}", c.docIDs["MessengerGraphQLThreadFetcher"], id))
-	resp, err := c.postForm(cAPIURL, post)
+	r, err := c.Batch().Thread(id).Do(context.Background())
 	if err != nil {
-		return nil, errors.WithContext("error getting thread messages: ", err)
+		return nil, err
 	}
-	var list messages
-	err = json.NewDecoder(resp.Body).Decode(&list)
-	if err != nil {
-		return nil, errors.WithContext("error decoding thread message list: ", err)
-	}
-	if list.Error.APIErrorCode != 0 {
-		return nil, list.Error
-	}
-	ms := make(Messages, 0, len(list.List.Data.MessageThread.Messages.Nodes))
-	for _, node := range list.List.Data.MessageThread.Messages.Nodes {
+	return r.Threads[id], nil
+}
+
+func parseMessages(list messagesData) Messages {
+	ms := make(Messages, 0, len(list.Data.MessageThread.Messages.Nodes))
+	for _, node := range list.Data.MessageThread.Messages.Nodes {
 		ms = append(ms, Message{
 			Message: node.Message.Text,
 			Sender:  node.Sender.ID,
@@ -285,7 +375,7 @@ func (c *Client) GetThread(id string) (Messages, error) {
 		})
 	}
 	sort.Sort(ms)
-	return ms, nil
+	return ms
 }
 
 func unixToTime(str string) time.Time {
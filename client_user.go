@@ -1,5 +1,13 @@
 package messenger
 
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"vimagination.zapto.org/errors"
+)
+
 type Gender byte
 
 const (
@@ -30,10 +38,25 @@ func getGender(gender string) Gender {
 	}
 }
 
+func getGenderID(gender int) Gender {
+	switch gender {
+	case 1:
+		return GenderFemale
+	case 2:
+		return GenderMale
+	default:
+		return GenderNeuter
+	}
+}
+
 type User struct {
 	ID                        string
 	Name, ShortName, Username string
 	Gender                    Gender
+	Updated                   time.Time
+	Active                    bool
+	LastActive                time.Time
+	Blocked                   bool
 }
 
 func (c *Client) SetUser(u User) {
@@ -42,9 +65,123 @@ func (c *Client) SetUser(u User) {
 	c.dataMu.Unlock()
 }
 
+func (c *Client) User(id string) (User, bool) {
+	c.dataMu.RLock()
+	u, ok := c.users[id]
+	c.dataMu.RUnlock()
+	return u, ok
+}
+
 func (c *Client) setUser(u User) {
-	if _, ok := c.users[u.ID]; ok {
+	if u.Updated.IsZero() {
+		u.Updated = time.Now()
+	}
+	existing, ok := c.users[u.ID]
+	if !ok {
+		c.users[u.ID] = u
 		return
 	}
-	c.users[u.ID] = u
+	if u.Name != "" {
+		existing.Name = u.Name
+	}
+	if u.ShortName != "" {
+		existing.ShortName = u.ShortName
+	}
+	if u.Username != "" {
+		existing.Username = u.Username
+	}
+	if u.Gender != 0 {
+		existing.Gender = u.Gender
+	}
+	if u.Updated.After(existing.Updated) {
+		existing.Updated = u.Updated
+	}
+	c.users[u.ID] = existing
+}
+
+type userProfiles struct {
+	Profiles map[string]struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		FirstName string `json:"firstName"`
+		Vanity    string `json:"vanity"`
+		Gender    int    `json:"gender"`
+	} `json:"profiles"`
+}
+
+const maxUserInfoIDs = 100
+
+func (c *Client) RefreshUsers(maxAge time.Duration) error {
+	stale := time.Now().Add(-maxAge)
+	var ids []string
+	c.dataMu.RLock()
+	for id, u := range c.users {
+		if u.Updated.Before(stale) {
+			ids = append(ids, id)
+		}
+	}
+	c.dataMu.RUnlock()
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxUserInfoIDs {
+			n = maxUserInfoIDs
+		}
+		if err := c.fetchUsers(ids[:n]); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+func (c *Client) fetchUsers(ids []string) error {
+	post := make(url.Values)
+	for n, id := range ids {
+		post.Set("ids["+strconv.Itoa(n)+"]", id)
+	}
+	var profiles userProfiles
+	if err := c.readAJAX(cUserInfoURL, post, &profiles); err != nil {
+		return errors.WithContext("error getting user info: ", err)
+	}
+	now := time.Now()
+	c.dataMu.Lock()
+	for _, profile := range profiles.Profiles {
+		c.setUser(User{
+			ID:        profile.ID,
+			Name:      profile.Name,
+			ShortName: profile.FirstName,
+			Username:  profile.Vanity,
+			Gender:    getGenderID(profile.Gender),
+			Updated:   now,
+		})
+	}
+	c.dataMu.Unlock()
+	return nil
+}
+
+func (c *Client) BlockUser(id string) error {
+	return c.setBlocked(id, true)
+}
+
+func (c *Client) UnblockUser(id string) error {
+	return c.setBlocked(id, false)
+}
+
+func (c *Client) setBlocked(id string, blocked bool) error {
+	u := cUnblockURL
+	if blocked {
+		u = cBlockURL
+	}
+	if err := c.postAJAX(u+"?fbid="+url.QueryEscape(id), make(url.Values), nil); err != nil {
+		return errors.WithContext("error changing block status: ", err)
+	}
+	c.dataMu.Lock()
+	user, ok := c.users[id]
+	if !ok {
+		user.ID = id
+	}
+	user.Blocked = blocked
+	c.users[id] = user
+	c.dataMu.Unlock()
+	return nil
 }
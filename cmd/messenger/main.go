@@ -2,6 +2,7 @@ package main // import "vimagination.zapto.org/messenger/cmd/messenger"
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -32,6 +33,24 @@ var (
 	cc = make(chan struct{})
 )
 
+func login(username, password string, opts []messenger.Option) (*messenger.Client, error) {
+	client, err := messenger.Login(username, password, opts...)
+	var twoFactor *messenger.TwoFactorError
+	if !errors.As(err, &twoFactor) {
+		return client, err
+	}
+	for {
+		code, err := UI.GetCode()
+		if err != nil {
+			return nil, err
+		}
+		if client, err = twoFactor.Submit(code); err != messenger.ErrInvalidTwoFactor {
+			return client, err
+		}
+		UI.ShowError("Invalid Login Code")
+	}
+}
+
 func main() {
 
 	go func() {
@@ -54,11 +73,18 @@ func main() {
 
 	e("error initialising ncurses", UI.Init())
 
-	var configFile string
+	var configFile, captureDir string
 	usr, err := user.Current()
 	e("error getting user information", err)
 	flag.StringVar(&configFile, "config", filepath.Join(usr.HomeDir, ".messengerConfig"), "path to configuration file")
+	flag.StringVar(&captureDir, "capture", "", "directory to record HTTP traffic to, for debugging")
 	flag.Parse()
+	opts := []messenger.Option{messenger.WithSessionCache()}
+	if captureDir != "" {
+		recorder, err := messenger.NewDirRecorder(captureDir)
+		e("error creating capture directory", err)
+		opts = append(opts, messenger.WithRecorder(recorder))
+	}
 	var config Config
 	f, err := os.Open(configFile)
 	if !os.IsNotExist(err) {
@@ -68,6 +94,7 @@ func main() {
 	}
 
 	if config.Client != nil {
+		config.Client.SetOptions(messenger.WithSessionCache())
 		if err = config.Client.Resume(); err == messenger.ErrInvalidCookies {
 			config.Client = nil
 		} else if err != nil {
@@ -75,7 +102,7 @@ func main() {
 		}
 	}
 	if config.Client == nil && config.Username != "" {
-		config.Client, err = messenger.Login(config.Username, config.Password)
+		config.Client, err = login(config.Username, config.Password, opts)
 		if err != messenger.ErrInvalidLogin {
 			e("error logging in with saved credentials", err)
 		}
@@ -85,7 +112,7 @@ func main() {
 		for {
 			username, password, err = UI.GetUserPass()
 			e("error getting username/password: ", err)
-			config.Client, err = messenger.Login(username, password)
+			config.Client, err = login(username, password, opts)
 			if err == messenger.ErrInvalidLogin {
 				UI.ShowError("Invalid Login Credentials")
 				continue
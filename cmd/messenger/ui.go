@@ -31,6 +31,16 @@ func (u *ui) GetUserPass() (string, string, error) {
 	return username, password, nil
 }
 
+func (u *ui) GetCode() (string, error) {
+	u.window.Printf("Enter Login Code: ")
+	code, err := u.window.GetString(10)
+	if err != nil {
+		return "", err
+	}
+	u.window.Println()
+	return code, nil
+}
+
 func (u *ui) ShowError(err string) {
 	u.window.Clear()
 	u.window.Println(err)
@@ -0,0 +1,129 @@
+package messenger
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+type DocIDCache interface {
+	Load(revision string) (map[string]string, error)
+	Store(revision string, docIDs map[string]string) error
+	Invalidate(revision string) error
+}
+
+func WithDocIDCache(cache DocIDCache) Option {
+	return func(c *Client) {
+		c.docIDCache = cache
+	}
+}
+
+type DirDocIDCache string
+
+func (d DirDocIDCache) path(revision string) string {
+	return filepath.Join(string(d), "docids_"+url.PathEscape(revision)+".json")
+}
+
+func (d DirDocIDCache) Load(revision string) (map[string]string, error) {
+	f, err := os.Open(d.path(revision))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var docIDs map[string]string
+	if err = json.NewDecoder(f).Decode(&docIDs); err != nil {
+		return nil, err
+	}
+	return docIDs, nil
+}
+
+func (d DirDocIDCache) Store(revision string, docIDs map[string]string) error {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(string(d), "docids_*.tmp")
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(f).Encode(docIDs); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), d.path(revision))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (d DirDocIDCache) Invalidate(revision string) error {
+	if err := os.Remove(d.path(revision)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func docIDCacheKey(revision string) string {
+	docIDMu.RLock()
+	modules := make([]string, 0, len(docIDModules))
+	for module, key := range docIDModules {
+		modules = append(modules, module+"\x00"+key)
+	}
+	names := make([]string, 0, len(bootloads))
+	for name := range bootloads {
+		names = append(names, name)
+	}
+	docIDMu.RUnlock()
+	sort.Strings(modules)
+	sort.Strings(names)
+	h := fnv.New64a()
+	for _, m := range modules {
+		io.WriteString(h, m+"\x00")
+	}
+	h.Write([]byte{1})
+	for _, name := range names {
+		io.WriteString(h, name+"\x00")
+	}
+	return revision + "_" + strconv.FormatUint(h.Sum64(), 36)
+}
+
+func (c *Client) cachedDocIDs(revision string) map[string]string {
+	if c.docIDCache == nil || revision == "" {
+		return nil
+	}
+	docIDs, err := c.docIDCache.Load(docIDCacheKey(revision))
+	if err != nil || len(docIDs) == 0 {
+		return nil
+	}
+	for _, key := range queryDocIDs {
+		if _, ok := docIDs[key]; !ok {
+			return nil
+		}
+	}
+	return docIDs
+}
+
+func (c *Client) storeDocIDs(revision string, docIDs map[string]string) {
+	if c.docIDCache != nil && revision != "" {
+		c.docIDCache.Store(docIDCacheKey(revision), docIDs)
+	}
+}
+
+func (c *Client) invalidateDocIDs() {
+	c.dataMu.RLock()
+	revision := c.revision
+	c.dataMu.RUnlock()
+	if c.docIDCache != nil && revision != "" {
+		c.docIDCache.Invalidate(docIDCacheKey(revision))
+	}
+}
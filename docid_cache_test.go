@@ -0,0 +1,74 @@
+package messenger
+
+import (
+	"reflect"
+	"testing"
+)
+
+type memDocIDCache map[string]map[string]string
+
+func (m memDocIDCache) Load(revision string) (map[string]string, error) {
+	return m[revision], nil
+}
+
+func (m memDocIDCache) Store(revision string, docIDs map[string]string) error {
+	m[revision] = docIDs
+	return nil
+}
+
+func (m memDocIDCache) Invalidate(revision string) error {
+	delete(m, revision)
+	return nil
+}
+
+func TestDocIDCache(t *testing.T) {
+	for n, test := range []struct {
+		Register func()
+		Cleanup  func()
+	}{
+		{
+			Register: func() { RegisterDocID("MessengerTestWebGraphQLQuery", "MessengerGraphQLTestFetcher") },
+			Cleanup:  func() { delete(docIDModules, "MessengerTestWebGraphQLQuery") },
+		},
+		{
+			Register: func() { RegisterDocID("MessengerThreadsWebGraphQLQuery", "MessengerGraphQLTestFetcher") },
+			Cleanup:  func() { docIDModules["MessengerThreadsWebGraphQLQuery"] = "MessengerGraphQLThreadFetcher" },
+		},
+		{
+			Register: func() { RegisterBootload("MessengerGraphQLTestFetcher.bs") },
+			Cleanup:  func() { delete(bootloads, "MessengerGraphQLTestFetcher.bs") },
+		},
+	} {
+		docIDs := map[string]string{
+			"MessengerGraphQLThreadlistFetcher": "1",
+			"MessengerGraphQLThreadFetcher":     "2",
+		}
+		cache := make(memDocIDCache)
+		c := &Client{docIDCache: cache, revision: "1000"}
+		c.storeDocIDs("1000", docIDs)
+		if got := c.cachedDocIDs("1000"); !reflect.DeepEqual(got, docIDs) {
+			t.Errorf("test %d: expecting cached doc IDs %v, got %v", n+1, docIDs, got)
+		} else if got := c.cachedDocIDs("1001"); got != nil {
+			t.Errorf("test %d: expecting no doc IDs for other revision, got %v", n+1, got)
+		}
+		test.Register()
+		got := c.cachedDocIDs("1000")
+		docIDMu.Lock()
+		test.Cleanup()
+		docIDMu.Unlock()
+		if got != nil {
+			t.Errorf("test %d: expecting cache miss after registration, got %v", n+1, got)
+		} else if got := c.cachedDocIDs("1000"); !reflect.DeepEqual(got, docIDs) {
+			t.Errorf("test %d: expecting cached doc IDs %v, got %v", n+1, docIDs, got)
+		}
+		c.invalidateDocIDs()
+		if got := c.cachedDocIDs("1000"); got != nil || len(cache) != 0 {
+			t.Errorf("test %d: expecting invalidated cache, got %v", n+1, got)
+		}
+	}
+	c := &Client{docIDCache: memDocIDCache{}}
+	c.storeDocIDs("1000", map[string]string{"MessengerGraphQLThreadlistFetcher": "1"})
+	if got := c.cachedDocIDs("1000"); got != nil {
+		t.Errorf("expecting incomplete doc IDs to be ignored, got %v", got)
+	}
+}
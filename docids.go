@@ -0,0 +1,52 @@
+package messenger
+
+import "sync"
+
+var (
+	docIDMu      sync.RWMutex
+	docIDModules = map[string]string{
+		"MessengerThreadlistWebGraphQLQuery":             "MessengerGraphQLThreadlistFetcher",
+		"MessengerThreadsWebGraphQLQuery":                "MessengerGraphQLThreadFetcher",
+		"MessengerSearchWebGraphQLQuery":                 "MessengerGraphQLSearchFetcher",
+		"MessengerParticipantsWebGraphQLQuery":           "MessengerGraphQLParticipantsFetcher",
+		"MessengerSharedMediaWebGraphQLQuery":            "MessengerGraphQLSharedMediaFetcher",
+		"MessengerMessageReactionWebGraphQLMutation":     "MessengerGraphQLMessageReactionMutation",
+		"MessengerThreadNameWebGraphQLMutation":          "MessengerGraphQLThreadNameMutation",
+		"MessengerThreadParticipantsWebGraphQLMutation":  "MessengerGraphQLThreadParticipantsMutation",
+		"MessengerThreadCustomizationWebGraphQLMutation": "MessengerGraphQLThreadCustomizationMutation",
+	}
+	bootloads = map[string]struct{}{
+		"MessengerGraphQLThreadlistFetcher.bs":   {},
+		"MessengerGraphQLThreadFetcher.bs":       {},
+		"MessengerGraphQLSearchFetcher.bs":       {},
+		"MessengerGraphQLParticipantsFetcher.bs": {},
+		"MessengerGraphQLSharedMediaFetcher.bs":  {},
+		"MessengerGraphQLMutations.bs":           {},
+	}
+)
+
+func RegisterDocID(module, key string) {
+	docIDMu.Lock()
+	docIDModules[module] = key
+	docIDMu.Unlock()
+}
+
+func RegisterBootload(name string) {
+	docIDMu.Lock()
+	bootloads[name] = struct{}{}
+	docIDMu.Unlock()
+}
+
+func docIDKey(module string) string {
+	docIDMu.RLock()
+	key := docIDModules[module]
+	docIDMu.RUnlock()
+	return key
+}
+
+func isBootload(name string) bool {
+	docIDMu.RLock()
+	_, ok := bootloads[name]
+	docIDMu.RUnlock()
+	return ok
+}
@@ -0,0 +1,171 @@
+package messenger
+
+import (
+	"encoding/base64"
+	"math/bits"
+	"strings"
+
+	"vimagination.zapto.org/errors"
+)
+
+const (
+	dynAlphabet      = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_"
+	maxDynBitmapBits = 1 << 20
+)
+
+var encoder = base64.NewEncoding(dynAlphabet).WithPadding(base64.NoPadding)
+
+type DynBitmap struct {
+	words []uint64
+}
+
+func (d *DynBitmap) Set(i uint) {
+	w := int(i / 64)
+	if w >= len(d.words) {
+		d.words = append(d.words, make([]uint64, w+1-len(d.words))...)
+	}
+	d.words[w] |= 1 << (i % 64)
+}
+
+func (d *DynBitmap) Has(i uint) bool {
+	w := int(i / 64)
+	return w < len(d.words) && d.words[w]&(1<<(i%64)) != 0
+}
+
+func (d *DynBitmap) Len() uint {
+	for n := len(d.words) - 1; n >= 0; n-- {
+		if d.words[n] != 0 {
+			return uint(n*64 + bits.Len64(d.words[n]))
+		}
+	}
+	return 0
+}
+
+func (d *DynBitmap) Bits() []uint {
+	var set []uint
+	for n, w := range d.words {
+		for ; w != 0; w &= w - 1 {
+			set = append(set, uint(n*64+bits.TrailingZeros64(w)))
+		}
+	}
+	return set
+}
+
+func (d *DynBitmap) Encode() string {
+	n := d.Len()
+	if n == 0 {
+		return ""
+	}
+	var w bitWriter
+	curr := d.Has(0)
+	w.writeBit(curr)
+	count := uint(1)
+	for i := uint(1); i < n; i++ {
+		if d.Has(i) == curr {
+			count++
+			continue
+		}
+		w.writeCount(count)
+		curr = !curr
+		count = 1
+	}
+	w.writeCount(count)
+	return encoder.EncodeToString(w.buf)[:(w.pos+5)/6]
+}
+
+func (d DynBitmap) MarshalText() ([]byte, error) {
+	return []byte(d.Encode()), nil
+}
+
+func (d *DynBitmap) UnmarshalText(text []byte) error {
+	return d.Decode(string(text))
+}
+
+func (d *DynBitmap) Decode(s string) error {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(dynAlphabet, s[i]) < 0 {
+			return ErrInvalidDynBitmap
+		}
+	}
+	var nd DynBitmap
+	r := bitReader{s: s}
+	curr, ok := r.readBit()
+	for pos := uint(0); ok; curr = !curr {
+		var (
+			zeros uint
+			bit   bool
+		)
+		for bit, ok = r.readBit(); ok && !bit; bit, ok = r.readBit() {
+			zeros++
+		}
+		if !ok {
+			break
+		}
+		count := uint(1)
+		for ; zeros > 0; zeros-- {
+			bit, _ = r.readBit()
+			count <<= 1
+			if bit {
+				count |= 1
+			}
+			if count > maxDynBitmapBits {
+				return ErrInvalidDynBitmap
+			}
+		}
+		if pos += count; pos > maxDynBitmapBits {
+			return ErrInvalidDynBitmap
+		}
+		if curr {
+			for i := pos - count; i < pos; i++ {
+				nd.Set(i)
+			}
+		}
+	}
+	*d = nd
+	return nil
+}
+
+type bitWriter struct {
+	buf []byte
+	pos uint
+}
+
+func (b *bitWriter) writeBit(bit bool) {
+	if int(b.pos/8) >= len(b.buf) {
+		b.buf = append(b.buf, 0)
+	}
+	if bit {
+		b.buf[b.pos/8] |= 0x80 >> (b.pos % 8)
+	}
+	b.pos++
+}
+
+func (b *bitWriter) writeCount(n uint) {
+	l := bits.Len(n)
+	for i := 1; i < l; i++ {
+		b.writeBit(false)
+	}
+	for i := l - 1; i >= 0; i-- {
+		b.writeBit(n>>uint(i)&1 == 1)
+	}
+}
+
+type bitReader struct {
+	s   string
+	pos uint
+}
+
+func (b *bitReader) readBit() (bool, bool) {
+	c := int(b.pos / 6)
+	if c >= len(b.s) {
+		return false, false
+	}
+	v := strings.IndexByte(dynAlphabet, b.s[c])
+	bit := v>>(5-b.pos%6)&1 == 1
+	b.pos++
+	return bit, true
+}
+
+const (
+	ErrInvalidDynBitmap errors.Error = "invalid dyn bitmap"
+)
@@ -0,0 +1,118 @@
+package messenger
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func toCompressedString(set []uint) string {
+	if len(set) == 0 {
+		return ""
+	}
+	bits := make([]byte, set[len(set)-1]+1)
+	for _, b := range set {
+		bits[b] = 1
+	}
+	count := func(n int) string {
+		b := strconv.FormatInt(int64(n), 2)
+		return strings.Repeat("0", len(b)-1) + b
+	}
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(int(bits[0])))
+	last, n := bits[0], 1
+	for _, b := range bits[1:] {
+		if b == last {
+			n++
+			continue
+		}
+		sb.WriteString(count(n))
+		last, n = b, 1
+	}
+	sb.WriteString(count(n))
+	sb.WriteString("00000")
+	s := sb.String()
+	var out strings.Builder
+	for i := 0; i+6 <= len(s); i += 6 {
+		v, _ := strconv.ParseUint(s[i:i+6], 2, 8)
+		out.WriteByte(dynAlphabet[v])
+	}
+	return out.String()
+}
+
+func bitRange(from, to, step uint) []uint {
+	var set []uint
+	for i := from; i < to; i += step {
+		set = append(set, i)
+	}
+	return set
+}
+
+func TestDynBitmapRoundTrip(t *testing.T) {
+	for n, test := range []struct {
+		Bits    []uint
+		Encoded string
+	}{
+		{},
+		{Bits: []uint{0}, Encoded: "M"},
+		{Bits: []uint{1}, Encoded: "o"},
+		{Bits: bitRange(0, 64, 1), Encoded: "wg0"},
+		{Bits: []uint{3, 5000}},
+		{Bits: []uint{12, 258, 317, 323, 2111}},
+		{Bits: bitRange(0, 200, 1)},
+		{Bits: bitRange(1, 300, 2)},
+		{Bits: []uint{0, 2047, 2048, 4095, 10000}},
+		{Bits: bitRange(100, 6000, 7)},
+		{Bits: bitRange(0, 2049, 1)},
+	} {
+		var d DynBitmap
+		for _, b := range test.Bits {
+			d.Set(b)
+		}
+		encoded := d.Encode()
+		if expected := toCompressedString(test.Bits); encoded != expected {
+			t.Errorf("test %d: expecting encoding %q, got %q", n+1, expected, encoded)
+		} else if test.Encoded != "" && encoded != test.Encoded {
+			t.Errorf("test %d: expecting encoding %q, got %q", n+1, test.Encoded, encoded)
+		}
+		var e DynBitmap
+		if err := e.Decode(encoded); err != nil {
+			t.Errorf("test %d: unexpected error: %s", n+1, err)
+		} else if !reflect.DeepEqual(e.Bits(), d.Bits()) {
+			t.Errorf("test %d: expecting bits %v, got %v", n+1, d.Bits(), e.Bits())
+		}
+	}
+}
+
+func TestDynBitmapMalformed(t *testing.T) {
+	for n, test := range []string{
+		"!!",
+		"ab cd",
+		"7A=",
+		"\xff",
+		"w0000_____",
+		"w00000000000" + strings.Repeat("_", 12),
+	} {
+		d := DynBitmap{words: []uint64{1}}
+		if err := d.Decode(test); err != ErrInvalidDynBitmap {
+			t.Errorf("test %d: expecting ErrInvalidDynBitmap, got %v", n+1, err)
+		} else if !d.Has(0) {
+			t.Errorf("test %d: bitmap modified on error", n+1)
+		}
+	}
+}
+
+func TestDynBitmapCaptured(t *testing.T) {
+	for n, test := range []string{
+		"7AgNe-4amaxx2u6aJGi9FxqeCwKyWgS8zQC-C267UKewWhE98nwgUaqwHx24UJi28rxuF8W49XDG4XzEa8iGta3iaVVojxCVEiGt0gKum4UpKq4GwwzEqxmaxm3m",
+		"7xeUmBwjbg7ebwKBWo5O12wAxu13wqovzEdEc8uw9-3K0lW4o3Bw5VCwjE3awbG78b87C1xwEwgolzUO0n2US2G3i0Boy1PwBgao6C0Mo5W3S1lwlE-U2exi4UaEW0D888cobEaU2eU5O0HUkyE9E2cwNwKwFxe0H8",
+	} {
+		var d DynBitmap
+		if err := d.Decode(test); err != nil {
+			t.Errorf("test %d: unexpected error: %s", n+1, err)
+		} else if encoded := d.Encode(); encoded != test {
+			t.Errorf("test %d: expecting re-encoding %q, got %q", n+1, test, encoded)
+		}
+	}
+}
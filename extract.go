@@ -0,0 +1,368 @@
+package messenger
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	xmlpath "gopkg.in/xmlpath.v2"
+	"vimagination.zapto.org/errors"
+)
+
+type DataItem int
+
+const (
+	ItemUserData DataItem = iota
+	ItemDTSG
+	ItemSiteData
+	ItemSprinkle
+	ItemBitmap
+	ItemThreadData
+	ItemResources
+	ItemDocIDs
+	ItemCookie
+	ItemLSD
+)
+
+func (d DataItem) String() string {
+	switch d {
+	case ItemUserData:
+		return "User Data"
+	case ItemDTSG:
+		return "DTSG Token"
+	case ItemSiteData:
+		return "Site Data"
+	case ItemSprinkle:
+		return "Sprinkle"
+	case ItemBitmap:
+		return "Bitmap"
+	case ItemThreadData:
+		return "Thread Data"
+	case ItemResources:
+		return "Resources"
+	case ItemDocIDs:
+		return "Doc IDs"
+	case ItemCookie:
+		return "Cookie"
+	case ItemLSD:
+		return "LSD Token"
+	default:
+		return "Unknown"
+	}
+}
+
+type PageData struct {
+	UserID, Name, ShortName string
+	DTSG                    string
+	Revision                string
+	SiteData                url.Values
+	HasteSession            string
+	PixelRatio              string
+	SprinkleName            string
+	Bitmap                  map[int64]struct{}
+	ThreadData              json.RawMessage
+	Resources               map[string][]string
+	ResourceCSR             map[string][]int64
+	DocIDs                  map[string]string
+	Cookie                  string
+	LSD                     string
+}
+
+func (d *PageData) Has(item DataItem) bool {
+	switch item {
+	case ItemUserData:
+		return d.UserID != ""
+	case ItemDTSG:
+		return d.DTSG != ""
+	case ItemSiteData:
+		return d.SiteData != nil
+	case ItemSprinkle:
+		return d.SprinkleName != ""
+	case ItemBitmap:
+		return len(d.Bitmap) > 0
+	case ItemThreadData:
+		return len(d.ThreadData) > 0
+	case ItemResources:
+		return len(d.Resources) > 0
+	case ItemDocIDs:
+		return len(d.DocIDs) > 0
+	case ItemCookie:
+		return d.Cookie != ""
+	case ItemLSD:
+		return d.LSD != ""
+	}
+	return false
+}
+
+func (d *PageData) copyItem(item DataItem, from *PageData) {
+	switch item {
+	case ItemUserData:
+		d.UserID, d.Name, d.ShortName = from.UserID, from.Name, from.ShortName
+	case ItemDTSG:
+		d.DTSG = from.DTSG
+	case ItemSiteData:
+		d.Revision, d.SiteData = from.Revision, from.SiteData
+		d.HasteSession, d.PixelRatio = from.HasteSession, from.PixelRatio
+	case ItemSprinkle:
+		d.SprinkleName = from.SprinkleName
+	case ItemBitmap:
+		d.Bitmap = from.Bitmap
+	case ItemThreadData:
+		d.ThreadData = from.ThreadData
+	case ItemResources:
+		d.Resources, d.ResourceCSR = from.Resources, from.ResourceCSR
+	case ItemDocIDs:
+		d.DocIDs = from.DocIDs
+	case ItemCookie:
+		d.Cookie = from.Cookie
+	case ItemLSD:
+		d.LSD = from.LSD
+	}
+}
+
+func (d *PageData) setBit(i int64) {
+	if d.Bitmap == nil {
+		d.Bitmap = make(map[int64]struct{})
+	}
+	d.Bitmap[i] = struct{}{}
+}
+
+func (d *PageData) addResource(key, src, csr string) {
+	if d.Resources == nil {
+		d.Resources = make(map[string][]string)
+	}
+	d.Resources[key] = append(d.Resources[key], src)
+	if ids := parseCSR(csr); len(ids) > 0 {
+		if d.ResourceCSR == nil {
+			d.ResourceCSR = make(map[string][]int64)
+		}
+		d.ResourceCSR[src] = ids
+	}
+}
+
+func parseCSR(csr string) []int64 {
+	var ids []int64
+	for _, id := range strings.Split(strings.TrimPrefix(csr, ":"), ",") {
+		if i, err := strconv.ParseInt(id, 10, 64); err == nil && i >= 0 {
+			ids = append(ids, i)
+		}
+	}
+	return ids
+}
+
+type Page struct {
+	Scripts []string
+
+	client *Client
+
+	jsDone bool
+	js     *PageData
+	jsErr  error
+
+	definesDone bool
+	defines     map[string]json.RawMessage
+	defineIDs   []int64
+
+	requiresDone bool
+	requires     map[string][]json.RawMessage
+
+	fetched map[string]string
+}
+
+func newPage(c *Client, nodes *xmlpath.Node) *Page {
+	p := &Page{
+		client: c,
+	}
+	for iter := pageScripts.Iter(nodes); iter.Next(); {
+		p.Scripts = append(p.Scripts, iter.Node().String())
+	}
+	return p
+}
+
+func (p *Page) Fetch(urls []string) ([]string, error) {
+	scripts, err := p.client.fetchResources(urls)
+	if err != nil {
+		return nil, err
+	}
+	if p.fetched == nil {
+		p.fetched = make(map[string]string, len(urls))
+	}
+	for n, url := range urls {
+		p.fetched[url] = scripts[n]
+	}
+	return scripts, nil
+}
+
+type Extractor interface {
+	Extract(item DataItem, page *Page, data *PageData) error
+}
+
+type ExtractorFunc func(DataItem, *Page, *PageData) error
+
+func (e ExtractorFunc) Extract(item DataItem, page *Page, data *PageData) error {
+	return e(item, page, data)
+}
+
+func WithExtractor(item DataItem, extractors ...Extractor) Option {
+	return func(c *Client) {
+		if c.extractors == nil {
+			c.extractors = make(map[DataItem][]Extractor)
+		}
+		c.extractors[item] = extractors
+	}
+}
+
+func (c *Client) extractorsFor(item DataItem) []Extractor {
+	if e, ok := c.extractors[item]; ok {
+		return e
+	}
+	if c.noJS {
+		return []Extractor{GoExtractor}
+	}
+	return defaultExtractors
+}
+
+func (c *Client) extract(page *Page, data *PageData, items ...DataItem) map[DataItem]error {
+	errs := make(map[DataItem]error)
+	for _, item := range items {
+		for _, e := range c.extractorsFor(item) {
+			if data.Has(item) {
+				break
+			}
+			if err := e.Extract(item, page, data); err != nil {
+				if _, ok := errs[item]; !ok {
+					errs[item] = err
+				}
+			}
+		}
+		if data.Has(item) {
+			delete(errs, item)
+		}
+	}
+	return errs
+}
+
+func (c *Client) ExtractErrors() map[DataItem]error {
+	c.dataMu.RLock()
+	errs := make(map[DataItem]error, len(c.extractErrs))
+	for item, err := range c.extractErrs {
+		errs[item] = err
+	}
+	c.dataMu.RUnlock()
+	return errs
+}
+
+var defineMarkers = [...]string{"handleDefines(", "\"define\":"}
+
+func (p *Page) parseDefines() {
+	if p.definesDone {
+		return
+	}
+	p.definesDone = true
+	p.defines = make(map[string]json.RawMessage)
+	for _, defs := range p.scanObjects(defineMarkers[:]) {
+		var entries [][]json.RawMessage
+		if json.Unmarshal(defs, &entries) != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if len(entry) < 3 {
+				continue
+			}
+			var name string
+			if json.Unmarshal(entry[0], &name) != nil {
+				continue
+			}
+			p.defines[name] = entry[2]
+			if len(entry) > 3 {
+				if id, err := strconv.ParseInt(string(entry[3]), 10, 64); err == nil {
+					p.defineIDs = append(p.defineIDs, id)
+				}
+			}
+		}
+	}
+}
+
+func (p *Page) define(name string, v interface{}) bool {
+	p.parseDefines()
+	data, ok := p.defines[name]
+	return ok && json.Unmarshal(data, v) == nil
+}
+
+type jsString string
+
+func (j *jsString) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	*j = jsString(s)
+	return nil
+}
+
+type defineExtractor struct{}
+
+var DefineExtractor Extractor = defineExtractor{}
+
+func (defineExtractor) Extract(item DataItem, p *Page, d *PageData) error {
+	switch item {
+	case ItemUserData:
+		var ud struct {
+			UserID    jsString `json:"USER_ID"`
+			Name      jsString `json:"NAME"`
+			ShortName jsString `json:"SHORT_NAME"`
+		}
+		if p.define("CurrentUserInitialData", &ud) {
+			d.UserID, d.Name, d.ShortName = string(ud.UserID), string(ud.Name), string(ud.ShortName)
+		}
+	case ItemDTSG:
+		var dtsg struct {
+			Token jsString `json:"token"`
+		}
+		if p.define("DTSGInitialData", &dtsg) {
+			d.DTSG = string(dtsg.Token)
+		}
+	case ItemSiteData:
+		var sd struct {
+			Revision     jsString `json:"server_revision"`
+			PkgCohortKey jsString `json:"pkg_cohort_key"`
+			PkgCohort    jsString `json:"pkg_cohort"`
+			BEKey        jsString `json:"be_key"`
+			BEMode       jsString `json:"be_mode"`
+			HasteSession jsString `json:"haste_session"`
+			PixelRatio   jsString `json:"pr"`
+		}
+		if p.define("SiteData", &sd) {
+			d.Revision = string(sd.Revision)
+			d.SiteData = make(url.Values)
+			d.SiteData.Set(string(sd.PkgCohortKey), string(sd.PkgCohort))
+			d.SiteData.Set(string(sd.BEKey), string(sd.BEMode))
+			d.HasteSession, d.PixelRatio = string(sd.HasteSession), string(sd.PixelRatio)
+		}
+	case ItemSprinkle:
+		var sc struct {
+			ParamName jsString `json:"param_name"`
+		}
+		if p.define("SprinkleConfig", &sc) {
+			d.SprinkleName = string(sc.ParamName)
+		}
+	case ItemLSD:
+		var lsd struct {
+			Token jsString `json:"token"`
+		}
+		if p.define("LSD", &lsd) {
+			d.LSD = string(lsd.Token)
+		}
+	case ItemBitmap:
+		p.parseDefines()
+		for _, id := range p.defineIDs {
+			d.setBit(id)
+		}
+	}
+	return nil
+}
+
+const (
+	ErrNoJS errors.Error = "built without JavaScript support"
+)
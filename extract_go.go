@@ -0,0 +1,173 @@
+package messenger
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+type goExtractor struct{}
+
+var GoExtractor Extractor = goExtractor{}
+
+func WithoutJS() Option {
+	return func(c *Client) {
+		c.noJS = true
+	}
+}
+
+var (
+	requireMarkers        = [...]string{"\"require\":"}
+	resourceMapMarkers    = [...]string{"setResourceMap(", "\"resource_map\":"}
+	enableBootloadMarkers = [...]string{"enableBootload("}
+	docIDModule           = regexp.MustCompile(`__d\(\s*["']([^"']+)["']`)
+	docIDValue            = regexp.MustCompile(`__getDocID\s*:\s*function\s*\(\s*\)\s*\{\s*return\s*["'](\d+)["']`)
+)
+
+type resource struct {
+	Type string `json:"type"`
+	Src  string `json:"src"`
+	P    string `json:"p"`
+}
+
+type bootload struct {
+	Resources []string `json:"resources"`
+}
+
+func (goExtractor) Extract(item DataItem, p *Page, d *PageData) error {
+	switch item {
+	case ItemCookie:
+		for _, req := range p.requireCalls("CookieCore") {
+			var args []jsString
+			if json.Unmarshal(req, &args) == nil && len(args) > 1 {
+				d.Cookie = string(args[1])
+				break
+			}
+		}
+	case ItemThreadData:
+		for _, req := range p.requireCalls("MessengerMount") {
+			var args []json.RawMessage
+			if json.Unmarshal(req, &args) != nil || len(args) < 2 {
+				continue
+			}
+			var mount struct {
+				GraphQLPayload struct {
+					ThreadList json.RawMessage `json:"thread_list"`
+				} `json:"graphqlPayload"`
+			}
+			if json.Unmarshal(args[1], &mount) == nil && len(mount.GraphQLPayload.ThreadList) > 0 {
+				d.ThreadData = mount.GraphQLPayload.ThreadList
+				break
+			}
+		}
+	case ItemResources:
+		resourceMap := make(map[string]resource)
+		for _, m := range p.scanObjects(resourceMapMarkers[:]) {
+			json.Unmarshal(m, &resourceMap)
+		}
+		for _, req := range p.requireCalls("Bootloader") {
+			var args []json.RawMessage
+			if json.Unmarshal(req, &args) == nil && len(args) > 0 {
+				json.Unmarshal(args[0], &resourceMap)
+			}
+		}
+		for _, b := range p.scanObjects(enableBootloadMarkers[:]) {
+			var bootloads map[string]bootload
+			if json.Unmarshal(b, &bootloads) != nil {
+				continue
+			}
+			for key, bl := range bootloads {
+				if !isBootload(key) {
+					continue
+				}
+				for _, name := range bl.Resources {
+					if res, ok := resourceMap[name]; ok && res.Type == "js" {
+						d.addResource(key, res.Src, res.P)
+					}
+				}
+			}
+		}
+	case ItemDocIDs:
+		if len(d.Resources) == 0 {
+			return nil
+		}
+		scripts, err := p.Fetch(resourceURLs(d.Resources))
+		if err != nil {
+			return err
+		}
+		docIDs := make(map[string]string)
+		for _, script := range scripts {
+			findDocIDs(script, docIDs)
+		}
+		d.DocIDs = docIDs
+	default:
+		return DefineExtractor.Extract(item, p, d)
+	}
+	return nil
+}
+
+func findDocIDs(script string, docIDs map[string]string) {
+	modules := docIDModule.FindAllStringSubmatchIndex(script, -1)
+	for n, m := range modules {
+		key := docIDKey(script[m[2]:m[3]])
+		if key == "" {
+			continue
+		}
+		end := len(script)
+		if n+1 < len(modules) {
+			end = modules[n+1][0]
+		}
+		if id := docIDValue.FindStringSubmatch(script[m[1]:end]); id != nil {
+			docIDs[key] = id[1]
+		}
+	}
+}
+
+func (p *Page) scanObjects(markers []string) []json.RawMessage {
+	var objs []json.RawMessage
+	for _, script := range p.Scripts {
+		for _, marker := range markers {
+			for pos := 0; ; {
+				i := strings.Index(script[pos:], marker)
+				if i < 0 {
+					break
+				}
+				pos += i + len(marker)
+				var obj json.RawMessage
+				if json.NewDecoder(strings.NewReader(script[pos:])).Decode(&obj) == nil {
+					objs = append(objs, obj)
+				}
+			}
+		}
+	}
+	return objs
+}
+
+func (p *Page) parseRequires() {
+	if p.requiresDone {
+		return
+	}
+	p.requiresDone = true
+	p.requires = make(map[string][]json.RawMessage)
+	for _, r := range p.scanObjects(requireMarkers[:]) {
+		var calls [][]json.RawMessage
+		if json.Unmarshal(r, &calls) != nil {
+			continue
+		}
+		for _, call := range calls {
+			if len(call) < 4 {
+				continue
+			}
+			var module string
+			if json.Unmarshal(call[0], &module) != nil {
+				continue
+			}
+			p.requires[module] = append(p.requires[module], call[3])
+		}
+	}
+}
+
+func (p *Page) requireCalls(module string) []json.RawMessage {
+	p.parseRequires()
+	return p.requires[module]
+}
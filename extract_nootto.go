@@ -0,0 +1,14 @@
+//go:build nootto
+// +build nootto
+
+package messenger
+
+type jsExtractor struct{}
+
+var JSExtractor Extractor = jsExtractor{}
+
+var defaultExtractors = []Extractor{GoExtractor}
+
+func (jsExtractor) Extract(DataItem, *Page, *PageData) error {
+	return ErrNoJS
+}
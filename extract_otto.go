@@ -0,0 +1,147 @@
+//go:build !nootto
+// +build !nootto
+
+package messenger
+
+import (
+	"net/url"
+
+	"github.com/robertkrimen/otto"
+	"vimagination.zapto.org/errors"
+)
+
+type jsExtractor struct{}
+
+var JSExtractor Extractor = jsExtractor{}
+
+var defaultExtractors = []Extractor{JSExtractor, GoExtractor}
+
+func (jsExtractor) Extract(item DataItem, p *Page, d *PageData) error {
+	if item == ItemDocIDs {
+		return jsDocIDs(p, d)
+	}
+	if !p.jsDone {
+		p.jsDone = true
+		p.js, p.jsErr = runPageScripts(p)
+	}
+	if p.jsErr != nil {
+		return p.jsErr
+	}
+	d.copyItem(item, p.js)
+	return nil
+}
+
+func runPageScripts(p *Page) (*PageData, error) {
+	d := &PageData{
+		Resources: make(map[string][]string),
+	}
+	si := stringIter(p.Scripts)
+	config := p.client.sandbox()
+	stats, err := runCode(
+		config,
+		jsFuncs{
+			"setCookieValue": func(call otto.FunctionCall) otto.Value {
+				d.Cookie = call.Argument(0).String()
+				return otto.UndefinedValue()
+			},
+			"setUserData": func(call otto.FunctionCall) otto.Value {
+				d.UserID = call.Argument(0).String()
+				d.Name = call.Argument(1).String()
+				d.ShortName = call.Argument(2).String()
+				return otto.UndefinedValue()
+			},
+			"setDTSGToken": func(call otto.FunctionCall) otto.Value {
+				d.DTSG = call.Argument(0).String()
+				return otto.UndefinedValue()
+			},
+			"setSiteData": func(call otto.FunctionCall) otto.Value {
+				d.Revision = call.Argument(0).String()
+				d.SiteData = make(url.Values)
+				d.SiteData.Set(call.Argument(1).String(), call.Argument(2).String())
+				d.SiteData.Set(call.Argument(3).String(), call.Argument(4).String())
+				d.HasteSession = jsOptString(call.Argument(5))
+				d.PixelRatio = jsOptString(call.Argument(6))
+				return otto.UndefinedValue()
+			},
+			"setLSD": func(call otto.FunctionCall) otto.Value {
+				d.LSD = call.Argument(0).String()
+				return otto.UndefinedValue()
+			},
+			"setSprinkleName": func(call otto.FunctionCall) otto.Value {
+				d.SprinkleName = call.Argument(0).String()
+				return otto.UndefinedValue()
+			},
+			"setBitmap": func(call otto.FunctionCall) otto.Value {
+				i, _ := call.Argument(0).ToInteger()
+				d.setBit(i)
+				return otto.UndefinedValue()
+			},
+			"isBootload": func(call otto.FunctionCall) otto.Value {
+				if isBootload(call.Argument(0).String()) {
+					return otto.TrueValue()
+				}
+				return otto.FalseValue()
+			},
+			"setResource": func(call otto.FunctionCall) otto.Value {
+				d.addResource(call.Argument(0).String(), call.Argument(1).String(), jsOptString(call.Argument(2)))
+				return otto.UndefinedValue()
+			},
+			"setThreadData": func(call otto.FunctionCall) otto.Value {
+				d.ThreadData = []byte(call.Argument(0).String())
+				return otto.UndefinedValue()
+			},
+			"handleDefine": func(call otto.FunctionCall) otto.Value {
+				name := call.Argument(0).String()
+				if fn, ok := config.funcs[name]; ok {
+					fn([]string{name, jsOptString(call.Argument(1))})
+				}
+				return otto.UndefinedValue()
+			},
+		},
+		&si,
+	)
+	p.client.addScriptStats(stats)
+	if err != nil {
+		return nil, errors.WithContext("error running page scripts: ", err)
+	}
+	return d, nil
+}
+
+func jsOptString(v otto.Value) string {
+	if v.IsUndefined() || v.IsNull() {
+		return ""
+	}
+	return v.String()
+}
+
+func jsDocIDs(p *Page, d *PageData) error {
+	if len(d.Resources) == 0 {
+		return nil
+	}
+	scripts, err := p.Fetch(resourceURLs(d.Resources))
+	if err != nil {
+		return err
+	}
+	si := stringIter(scripts)
+	docIDs := make(map[string]string, len(d.Resources))
+	stats, err := runCode(
+		p.client.sandbox(),
+		jsFuncs{
+			"getDocIDKey": func(call otto.FunctionCall) otto.Value {
+				key, _ := otto.ToValue(docIDKey(call.Argument(0).String()))
+				return key
+			},
+			"setID": func(call otto.FunctionCall) otto.Value {
+				docIDs[call.Argument(0).String()] = call.Argument(1).String()
+				return otto.UndefinedValue()
+			},
+		},
+		&si,
+	)
+	p.client.addScriptStats(stats)
+	if err != nil {
+		return errors.WithContext("error running resource scripts: ", err)
+	}
+	d.DocIDs = docIDs
+	return nil
+}
@@ -0,0 +1,120 @@
+package messenger
+
+import (
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPixelRatio   = "1"
+	defaultSprinkleName = "jazoest"
+)
+
+type Fingerprint struct {
+	Dyn          DynBitmap `json:"dyn"`
+	CSR          DynBitmap `json:"csr"`
+	HasteSession string    `json:"hs,omitempty"`
+	PixelRatio   string    `json:"dpr,omitempty"`
+	SprinkleName string    `json:"sprinkle_name,omitempty"`
+	Sprinkle     string    `json:"sprinkle,omitempty"`
+	LSD          string    `json:"lsd,omitempty"`
+}
+
+func newFingerprint(data *PageData) Fingerprint {
+	f := Fingerprint{
+		HasteSession: data.HasteSession,
+		PixelRatio:   data.PixelRatio,
+		SprinkleName: data.SprinkleName,
+		Sprinkle:     sprinkle(data.DTSG),
+		LSD:          data.LSD,
+	}
+	if f.PixelRatio == "" {
+		f.PixelRatio = defaultPixelRatio
+	}
+	for i := range data.Bitmap {
+		if i >= 0 {
+			f.Dyn.Set(uint(i))
+		}
+	}
+	for _, csr := range data.ResourceCSR {
+		for _, i := range csr {
+			if i >= 0 {
+				f.CSR.Set(uint(i))
+			}
+		}
+	}
+	return f
+}
+
+func sprinkle(dtsg string) string {
+	buf := make([]byte, 1, 3*len(dtsg)+1)
+	buf[0] = '2'
+	for _, char := range dtsg {
+		buf = strconv.AppendInt(buf, int64(char), 10)
+	}
+	return string(buf)
+}
+
+func (f *Fingerprint) clone() Fingerprint {
+	g := *f
+	g.Dyn.words = append([]uint64(nil), f.Dyn.words...)
+	g.CSR.words = append([]uint64(nil), f.CSR.words...)
+	return g
+}
+
+func (f *Fingerprint) setValues(data url.Values) {
+	for key, value := range map[string]string{
+		"__dyn":        f.Dyn.Encode(),
+		"__csr":        f.CSR.Encode(),
+		"__hs":         f.HasteSession,
+		"dpr":          f.PixelRatio,
+		"lsd":          f.LSD,
+		f.SprinkleName: f.Sprinkle,
+	} {
+		if key != "" && value != "" {
+			data.Set(key, value)
+		}
+	}
+}
+
+func (f *Fingerprint) fromPostData(postData url.Values) {
+	if dyn := postData.Get("__dyn"); dyn != "" {
+		f.Dyn.Decode(dyn)
+	}
+	if csr := postData.Get("__csr"); csr != "" {
+		f.CSR.Decode(csr)
+	}
+	if hs := postData.Get("__hs"); hs != "" {
+		f.HasteSession = hs
+	}
+	if dpr := postData.Get("dpr"); dpr != "" {
+		f.PixelRatio = dpr
+	}
+	if lsd := postData.Get("lsd"); lsd != "" {
+		f.LSD = lsd
+	}
+	if value := postData.Get(defaultSprinkleName); value != "" {
+		f.SprinkleName = defaultSprinkleName
+		f.Sprinkle = value
+	}
+	for _, key := range [...]string{"__dyn", "__csr", "__hs", "dpr", "lsd", defaultSprinkleName} {
+		postData.Del(key)
+	}
+}
+
+func (c *Client) Fingerprint() Fingerprint {
+	c.dataMu.RLock()
+	f := c.fingerprint.clone()
+	c.dataMu.RUnlock()
+	return f
+}
+
+func (f *Fingerprint) loadedResource(script string) {
+	p := Page{Scripts: []string{script}}
+	p.parseDefines()
+	for _, i := range p.defineIDs {
+		if i >= 0 {
+			f.Dyn.Set(uint(i))
+		}
+	}
+}
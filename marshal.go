@@ -1,11 +1,14 @@
 package messenger
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
 	"sync/atomic"
+	"time"
 
 	"vimagination.zapto.org/byteio"
 	"vimagination.zapto.org/errors"
@@ -19,6 +22,56 @@ type clientJSON struct {
 	Username      string            `json:"username"`
 	UsernameShort string            `json:"username_short"`
 	Request       uint64            `json:"request"`
+	Fingerprint   *Fingerprint      `json:"fingerprint,omitempty"`
+	Cache         *sessionCache     `json:"cache,omitempty"`
+}
+
+type sessionCache struct {
+	Synced  time.Time         `json:"synced"`
+	Threads map[string]Thread `json:"threads"`
+	Users   map[string]User   `json:"users"`
+}
+
+func WithSessionCache() Option {
+	return func(c *Client) {
+		c.dataMu.Lock()
+		c.cache = true
+		c.dataMu.Unlock()
+	}
+}
+
+func (c *Client) sessionCache() *sessionCache {
+	if !c.cache {
+		return nil
+	}
+	cache := &sessionCache{
+		Synced:  c.synced,
+		Threads: make(map[string]Thread, len(c.threads)),
+		Users:   make(map[string]User, len(c.users)),
+	}
+	for id, thread := range c.threads {
+		cache.Threads[id] = thread.clone()
+	}
+	for id, user := range c.users {
+		cache.Users[id] = user
+	}
+	return cache
+}
+
+func (c *Client) loadSessionCache(cache *sessionCache) {
+	c.threads = make(map[string]Thread)
+	c.users = make(map[string]User)
+	if cache == nil {
+		return
+	}
+	c.cache = true
+	c.synced = cache.Synced
+	for id, thread := range cache.Threads {
+		c.threads[id] = thread
+	}
+	for id, user := range cache.Users {
+		c.users[id] = user
+	}
 }
 
 func (c *Client) MarshalJSON() ([]byte, error) {
@@ -31,6 +84,7 @@ func (c *Client) MarshalJSON() ([]byte, error) {
 
 func (c *Client) MarshalJSONWriter(w io.Writer) error {
 	c.dataMu.RLock()
+	fingerprint := c.fingerprint.clone()
 	data := clientJSON{
 		Cookies:       c.client.Jar.Cookies(domain),
 		PostData:      c.postData,
@@ -38,6 +92,8 @@ func (c *Client) MarshalJSONWriter(w io.Writer) error {
 		Username:      c.username,
 		UsernameShort: c.usernameShort,
 		Request:       atomic.LoadUint64(&c.request),
+		Fingerprint:   &fingerprint,
+		Cache:         c.sessionCache(),
 	}
 	c.dataMu.RUnlock()
 	if err := json.NewEncoder(w).Encode(data); err != nil {
@@ -51,6 +107,9 @@ func (c *Client) UnmarshalJSON(b []byte) error {
 }
 
 func (c *Client) UnmarshalJSONReader(r io.Reader) error {
+	if atomic.LoadUint32(&c.loggedOut) == 1 {
+		return ErrLoggedOut
+	}
 	if c.docIDs != nil {
 		return ErrIntialised
 	}
@@ -67,19 +126,55 @@ func (c *Client) UnmarshalJSONReader(r io.Reader) error {
 	c.username = data.Username
 	c.usernameShort = data.UsernameShort
 	atomic.StoreUint64(&c.request, data.Request)
-	c.threads = make(map[string]Thread)
-	c.users = make(map[string]User)
+	if data.Fingerprint != nil {
+		c.fingerprint = *data.Fingerprint
+	} else if c.postData != nil {
+		c.fingerprint.fromPostData(c.postData)
+	}
+	c.loadSessionCache(data.Cache)
 	return nil
 }
 
-type stringWriter struct {
-	byteio.StickyLittleEndianWriter
-}
+const (
+	sessionMagic    = "\x89MSG"
+	sessionVersion  = 1
+	maxSessionField = 1 << 24
+)
 
-func (s *stringWriter) WriteString(str string) (int, error) {
-	s.WriteUint32(uint32(len(str)))
-	return s.Write([]byte(str))
-}
+const (
+	tagEnd = iota
+	tagCookies
+	tagPostData
+	tagDocIDs
+	tagUsername
+	tagUsernameShort
+	tagRequest
+	tagFingerprint
+	tagCache
+	tagCachedThread
+	tagCachedUser
+)
+
+const (
+	tagFingerprintDyn = iota + 1
+	tagFingerprintCSR
+	tagFingerprintHasteSession
+	tagFingerprintPixelRatio
+	tagFingerprintSprinkleName
+	tagFingerprintSprinkle
+	tagFingerprintLSD
+)
+
+const (
+	tagCacheSynced = iota + 1
+	tagCacheThread
+	tagCacheUser
+)
+
+const (
+	cookieHTTPOnly = 1 << iota
+	cookieSecure
+)
 
 func (c *Client) MarshalBinary() ([]byte, error) {
 	var b memio.Buffer
@@ -90,55 +185,238 @@ func (c *Client) MarshalBinary() ([]byte, error) {
 }
 
 func (c *Client) MarshalBinaryWriter(w io.Writer) error {
-	// cookies
-	// postdata
-	// username/usernameShort
-	// request
-	sw := stringWriter{
-		StickyLittleEndianWriter: byteio.StickyLittleEndianWriter{
-			Writer: w,
-		},
+	sw := sessionWriter{
+		buf: append([]byte(sessionMagic), sessionVersion),
 	}
 	c.dataMu.RLock()
 	cookies := c.client.Jar.Cookies(domain)
-	sw.WriteUint8(uint8(len(cookies)))
-	for _, cookie := range cookies {
-		sw.WriteString(cookie.Name)
-		sw.WriteString(cookie.Value)
-		sw.WriteString(cookie.Path)
-		sw.WriteString(cookie.Domain)
-		sw.WriteInt64(int64(cookie.MaxAge))
-		if cookie.HttpOnly {
-			sw.WriteUint8(1)
-		} else {
-			sw.WriteUint8(0)
+	sw.field(tagCookies, func(f *sessionWriter) {
+		f.count(len(cookies))
+		for _, cookie := range cookies {
+			f.string(cookie.Name)
+			f.string(cookie.Value)
+			f.string(cookie.Path)
+			f.string(cookie.Domain)
+			f.varint(int64(cookie.MaxAge))
+			var flags uint64
+			if cookie.HttpOnly {
+				flags |= cookieHTTPOnly
+			}
+			if cookie.Secure {
+				flags |= cookieSecure
+			}
+			f.uvarint(flags)
+			f.time(cookie.Expires)
+		}
+	})
+	sw.field(tagPostData, func(f *sessionWriter) {
+		f.count(len(c.postData))
+		for key, values := range c.postData {
+			f.string(key)
+			f.count(len(values))
+			for _, value := range values {
+				f.string(value)
+			}
+		}
+	})
+	sw.field(tagDocIDs, func(f *sessionWriter) {
+		f.count(len(c.docIDs))
+		for key, id := range c.docIDs {
+			f.string(key)
+			f.string(id)
 		}
-		if cookie.Secure {
-			sw.WriteUint8(1)
-		} else {
-			sw.WriteUint8(0)
+	})
+	sw.field(tagUsername, func(f *sessionWriter) {
+		f.string(c.username)
+	})
+	sw.field(tagUsernameShort, func(f *sessionWriter) {
+		f.string(c.usernameShort)
+	})
+	sw.field(tagRequest, func(f *sessionWriter) {
+		f.uvarint(atomic.LoadUint64(&c.request))
+	})
+	sw.field(tagFingerprint, func(f *sessionWriter) {
+		for tag, value := range [...]string{
+			tagFingerprintDyn:          c.fingerprint.Dyn.Encode(),
+			tagFingerprintCSR:          c.fingerprint.CSR.Encode(),
+			tagFingerprintHasteSession: c.fingerprint.HasteSession,
+			tagFingerprintPixelRatio:   c.fingerprint.PixelRatio,
+			tagFingerprintSprinkleName: c.fingerprint.SprinkleName,
+			tagFingerprintSprinkle:     c.fingerprint.Sprinkle,
+			tagFingerprintLSD:          c.fingerprint.LSD,
+		} {
+			if value != "" {
+				f.field(uint64(tag), func(f *sessionWriter) {
+					f.raw(value)
+				})
+			}
+		}
+		f.uvarint(tagEnd)
+	})
+	if cache := c.sessionCache(); cache != nil {
+		sw.field(tagCache, func(f *sessionWriter) {
+			f.field(tagCacheSynced, func(f *sessionWriter) {
+				f.time(cache.Synced)
+			})
+			f.uvarint(tagEnd)
+		})
+		for _, thread := range cache.Threads {
+			sw.optionalField(tagCachedThread, func(f *sessionWriter) {
+				f.thread(thread)
+			})
+		}
+		for _, user := range cache.Users {
+			sw.optionalField(tagCachedUser, func(f *sessionWriter) {
+				f.user(user)
+			})
 		}
-		buf, _ := cookie.Expires.MarshalBinary()
-		sw.WriteUint8(uint8(len(buf)))
-		sw.Write(buf)
 	}
-
-	sw.WriteUint8(uint8(len(c.postData)))
-	for key := range c.postData {
-		sw.WriteString(key)
-		sw.WriteString(c.postData.Get(key))
+	c.dataMu.RUnlock()
+	sw.uvarint(tagEnd)
+	if sw.err != nil {
+		return sw.err
 	}
-
-	sw.WriteUint8(uint8(len(c.docIDs)))
-	for key := range c.docIDs {
-		sw.WriteString(key)
-		sw.WriteString(c.docIDs[key])
+	if _, err := w.Write(sw.buf); err != nil {
+		return errors.WithContext("error writing session: ", err)
 	}
+	return nil
+}
 
-	sw.WriteString(c.username)
-	sw.WriteString(c.usernameShort)
-	sw.WriteUint64(atomic.LoadUint64(&c.request))
-	c.dataMu.RUnlock()
+func (c *Client) UnmarshalBinary(b []byte) error {
+	return c.UnmarshalBinaryReader((*memio.Buffer)(&b))
+}
+
+func (c *Client) UnmarshalBinaryReader(r io.Reader) error {
+	if atomic.LoadUint32(&c.loggedOut) == 1 {
+		return ErrLoggedOut
+	}
+	if c.docIDs != nil {
+		return ErrIntialised
+	}
+	var magic [len(sessionMagic)]byte
+	if n, err := io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != sessionMagic {
+		return c.unmarshalBinaryV0(io.MultiReader(bytes.NewReader(magic[:n]), r))
+	}
+	br, ok := r.(byteReader)
+	if !ok {
+		br = &singleByteReader{Reader: r}
+	}
+	version, err := br.ReadByte()
+	if err != nil {
+		return errors.WithContext("error reading session version: ", err)
+	}
+	if version != sessionVersion {
+		return ErrSessionVersion
+	}
+	var (
+		cookies     []*http.Cookie
+		postData    = make(url.Values)
+		docIDs      = make(map[string]string)
+		fingerprint Fingerprint
+		username    string
+		short       string
+		request     uint64
+		cache       *sessionCache
+	)
+	sr := sessionReader{r: br}
+	if err := sr.fields(func(tag uint64, f *sessionReader) {
+		if cache == nil && (tag == tagCache || tag == tagCachedThread || tag == tagCachedUser) {
+			cache = &sessionCache{
+				Threads: make(map[string]Thread),
+				Users:   make(map[string]User),
+			}
+		}
+		switch tag {
+		case tagCookies:
+			cookies = make([]*http.Cookie, 0, f.count())
+			for n := cap(cookies); n > 0 && f.err == nil; n-- {
+				cookie := &http.Cookie{
+					Name:   f.string(),
+					Value:  f.string(),
+					Path:   f.string(),
+					Domain: f.string(),
+					MaxAge: int(f.varint()),
+				}
+				flags := f.uvarint()
+				cookie.HttpOnly = flags&cookieHTTPOnly != 0
+				cookie.Secure = flags&cookieSecure != 0
+				cookie.Expires = f.time()
+				cookies = append(cookies, cookie)
+			}
+		case tagPostData:
+			for n := f.count(); n > 0 && f.err == nil; n-- {
+				key := f.string()
+				for m := f.count(); m > 0 && f.err == nil; m-- {
+					postData.Add(key, f.string())
+				}
+			}
+		case tagDocIDs:
+			for n := f.count(); n > 0 && f.err == nil; n-- {
+				key := f.string()
+				docIDs[key] = f.string()
+			}
+		case tagUsername:
+			username = f.string()
+		case tagUsernameShort:
+			short = f.string()
+		case tagRequest:
+			request = f.uvarint()
+		case tagFingerprint:
+			f.fields(func(tag uint64, f *sessionReader) {
+				value := f.rest()
+				switch tag {
+				case tagFingerprintDyn:
+					f.err = fingerprint.Dyn.Decode(value)
+				case tagFingerprintCSR:
+					f.err = fingerprint.CSR.Decode(value)
+				case tagFingerprintHasteSession:
+					fingerprint.HasteSession = value
+				case tagFingerprintPixelRatio:
+					fingerprint.PixelRatio = value
+				case tagFingerprintSprinkleName:
+					fingerprint.SprinkleName = value
+				case tagFingerprintSprinkle:
+					fingerprint.Sprinkle = value
+				case tagFingerprintLSD:
+					fingerprint.LSD = value
+				}
+			})
+		case tagCache:
+			f.fields(func(tag uint64, f *sessionReader) {
+				switch tag {
+				case tagCacheSynced:
+					cache.Synced = f.time()
+				case tagCacheThread:
+					thread := f.thread()
+					cache.Threads[thread.ID] = thread
+				case tagCacheUser:
+					user := f.user()
+					cache.Users[user.ID] = user
+				}
+			})
+		case tagCachedThread:
+			thread := f.thread()
+			cache.Threads[thread.ID] = thread
+		case tagCachedUser:
+			user := f.user()
+			cache.Users[user.ID] = user
+		}
+	}); err != nil {
+		return errors.WithContext("error reading session: ", err)
+	}
+	c.dataMu.Lock()
+	c.client.Jar = newJar()
+	if len(cookies) > 0 {
+		c.client.Jar.SetCookies(domain, cookies)
+	}
+	c.postData = postData
+	c.docIDs = docIDs
+	c.username = username
+	c.usernameShort = short
+	c.fingerprint = fingerprint
+	c.loadSessionCache(cache)
+	atomic.StoreUint64(&c.request, request)
+	c.dataMu.Unlock()
 	return nil
 }
 
@@ -152,14 +430,7 @@ func (s *stringReader) ReadString() string {
 	return string(buf[:n])
 }
 
-func (c *Client) UnmarshalBinary(b []byte) error {
-	return c.UnmarshalBinaryReader((*memio.Buffer)(&b))
-}
-
-func (c *Client) UnmarshalBinaryReader(r io.Reader) error {
-	if c.docIDs != nil {
-		return ErrIntialised
-	}
+func (c *Client) unmarshalBinaryV0(r io.Reader) error {
 	sr := stringReader{
 		StickyLittleEndianReader: byteio.StickyLittleEndianReader{
 			Reader: r,
@@ -204,6 +475,9 @@ func (c *Client) UnmarshalBinaryReader(r io.Reader) error {
 	c.usernameShort = sr.ReadString()
 	atomic.StoreUint64(&c.request, sr.ReadUint64())
 
+	c.fingerprint.fromPostData(c.postData)
+	c.loadSessionCache(nil)
+
 	c.dataMu.Unlock()
 	return sr.Err
 }
@@ -228,6 +502,262 @@ func (c *Client) UnmarshalTextReader(r io.Reader) error {
 	return c.UnmarshalJSONReader(r)
 }
 
+type sessionWriter struct {
+	buf []byte
+	err error
+}
+
+func (s *sessionWriter) uvarint(v uint64) {
+	s.buf = binary.AppendUvarint(s.buf, v)
+}
+
+func (s *sessionWriter) varint(v int64) {
+	s.buf = binary.AppendVarint(s.buf, v)
+}
+
+func (s *sessionWriter) count(n int) {
+	if n > maxSessionField {
+		s.err = ErrSessionTooLarge
+	}
+	s.uvarint(uint64(n))
+}
+
+func (s *sessionWriter) raw(str string) {
+	s.buf = append(s.buf, str...)
+}
+
+func (s *sessionWriter) string(str string) {
+	s.count(len(str))
+	s.raw(str)
+}
+
+func (s *sessionWriter) bytes(b []byte) {
+	s.count(len(b))
+	s.buf = append(s.buf, b...)
+}
+
+func (s *sessionWriter) bool(b bool) {
+	if b {
+		s.uvarint(1)
+	} else {
+		s.uvarint(0)
+	}
+}
+
+func (s *sessionWriter) time(t time.Time) {
+	b, _ := t.MarshalBinary()
+	s.bytes(b)
+}
+
+func (s *sessionWriter) thread(t Thread) {
+	s.string(t.ID)
+	s.string(t.Name)
+	s.varint(int64(t.Type))
+	s.varint(int64(t.Folder))
+	s.bool(t.Archived)
+	s.time(t.MutedUntil)
+	s.count(len(t.Participants))
+	for _, id := range t.Participants {
+		s.string(id)
+	}
+	s.count(len(t.ParticipantCustomisation))
+	for id, nickname := range t.ParticipantCustomisation {
+		s.string(id)
+		s.string(nickname)
+	}
+	s.varint(int64(t.UnreadCount))
+	s.varint(int64(t.MessageCount))
+	s.time(t.Updated)
+	s.string(t.LastMessage.Sender)
+	s.string(t.LastMessage.Snippet)
+	s.time(t.LastMessage.Time)
+}
+
+func (s *sessionWriter) user(u User) {
+	s.string(u.ID)
+	s.string(u.Name)
+	s.string(u.ShortName)
+	s.string(u.Username)
+	s.uvarint(uint64(u.Gender))
+	s.time(u.Updated)
+	s.bool(u.Active)
+	s.time(u.LastActive)
+	s.bool(u.Blocked)
+}
+
+func (s *sessionWriter) field(tag uint64, fn func(*sessionWriter)) {
+	var f sessionWriter
+	fn(&f)
+	if f.err != nil {
+		s.err = f.err
+	}
+	s.uvarint(tag)
+	s.bytes(f.buf)
+}
+
+func (s *sessionWriter) optionalField(tag uint64, fn func(*sessionWriter)) {
+	var f sessionWriter
+	fn(&f)
+	if f.err == nil && len(f.buf) <= maxSessionField {
+		s.uvarint(tag)
+		s.bytes(f.buf)
+	}
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type singleByteReader struct {
+	io.Reader
+	buf [1]byte
+}
+
+func (s *singleByteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(s.Reader, s.buf[:])
+	return s.buf[0], err
+}
+
+type sessionReader struct {
+	r   byteReader
+	err error
+}
+
+func (s *sessionReader) uvarint() uint64 {
+	if s.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(s.r)
+	s.err = err
+	return v
+}
+
+func (s *sessionReader) varint() int64 {
+	if s.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(s.r)
+	s.err = err
+	return v
+}
+
+func (s *sessionReader) count() int {
+	n := s.uvarint()
+	if r, ok := s.r.(interface{ Len() int }); ok && n > uint64(r.Len()) {
+		s.err = ErrInvalidSession
+		return 0
+	}
+	if n > maxSessionField {
+		s.err = ErrInvalidSession
+		return 0
+	}
+	return int(n)
+}
+
+func (s *sessionReader) bytes() []byte {
+	n := s.count()
+	if s.err != nil {
+		return nil
+	}
+	buf := make([]byte, n)
+	_, s.err = io.ReadFull(s.r, buf)
+	return buf
+}
+
+func (s *sessionReader) string() string {
+	return string(s.bytes())
+}
+
+func (s *sessionReader) bool() bool {
+	return s.uvarint() == 1
+}
+
+func (s *sessionReader) time() time.Time {
+	var t time.Time
+	if b := s.bytes(); s.err == nil {
+		if err := t.UnmarshalBinary(b); err != nil {
+			s.err = ErrInvalidSession
+		}
+	}
+	return t
+}
+
+func (s *sessionReader) thread() Thread {
+	t := Thread{
+		ID:         s.string(),
+		Name:       s.string(),
+		Type:       ThreadType(s.varint()),
+		Folder:     Folder(s.varint()),
+		Archived:   s.bool(),
+		MutedUntil: s.time(),
+	}
+	t.Participants = make([]string, 0, s.count())
+	for n := cap(t.Participants); n > 0 && s.err == nil; n-- {
+		t.Participants = append(t.Participants, s.string())
+	}
+	t.ParticipantCustomisation = make(map[string]string)
+	for n := s.count(); n > 0 && s.err == nil; n-- {
+		id := s.string()
+		t.ParticipantCustomisation[id] = s.string()
+	}
+	t.UnreadCount = int(s.varint())
+	t.MessageCount = int(s.varint())
+	t.Updated = s.time()
+	t.LastMessage.Sender = s.string()
+	t.LastMessage.Snippet = s.string()
+	t.LastMessage.Time = s.time()
+	return t
+}
+
+func (s *sessionReader) user() User {
+	return User{
+		ID:         s.string(),
+		Name:       s.string(),
+		ShortName:  s.string(),
+		Username:   s.string(),
+		Gender:     Gender(s.uvarint()),
+		Updated:    s.time(),
+		Active:     s.bool(),
+		LastActive: s.time(),
+		Blocked:    s.bool(),
+	}
+}
+
+func (s *sessionReader) rest() string {
+	if s.err != nil {
+		return ""
+	}
+	buf, err := io.ReadAll(s.r)
+	s.err = err
+	return string(buf)
+}
+
+func (s *sessionReader) fields(fn func(tag uint64, f *sessionReader)) error {
+	for s.err == nil {
+		tag := s.uvarint()
+		if s.err != nil || tag == tagEnd {
+			break
+		}
+		payload := s.bytes()
+		if s.err != nil {
+			break
+		}
+		f := sessionReader{r: bytes.NewReader(payload)}
+		fn(tag, &f)
+		if f.err != nil {
+			s.err = f.err
+		}
+	}
+	if s.err == io.EOF {
+		s.err = io.ErrUnexpectedEOF
+	}
+	return s.err
+}
+
 const (
-	ErrIntialised errors.Error = "already initialised"
+	ErrIntialised      errors.Error = "already initialised"
+	ErrInvalidSession  errors.Error = "invalid session data"
+	ErrSessionVersion  errors.Error = "unsupported session version"
+	ErrSessionTooLarge errors.Error = "session field too large"
 )
@@ -0,0 +1,475 @@
+package messenger
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"vimagination.zapto.org/byteio"
+)
+
+func newSessionClient() *Client {
+	c := &Client{
+		postData: url.Values{
+			"__a":     []string{"1"},
+			"__user":  []string{"100"},
+			"fb_dtsg": []string{"token"},
+		},
+		docIDs: map[string]string{
+			"MessengerGraphQLThreadlistFetcher": "123",
+			"MessengerGraphQLThreadFetcher":     "456",
+		},
+		username:      "Me Self",
+		usernameShort: "Me",
+		request:       42,
+		fingerprint: Fingerprint{
+			HasteSession: "hs",
+			PixelRatio:   "1",
+			SprinkleName: defaultSprinkleName,
+			Sprinkle:     sprinkle("token"),
+			LSD:          "lsd",
+		},
+	}
+	c.fingerprint.Dyn.Set(12)
+	c.fingerprint.Dyn.Set(2111)
+	c.fingerprint.CSR.Set(1843)
+	c.client.Jar = newJar()
+	c.client.Jar.SetCookies(domain, []*http.Cookie{
+		{Name: "c_user", Value: "100", Path: "/", Expires: time.Unix(2000000000, 0)},
+		{Name: "xs", Value: "secret", Path: "/", Expires: time.Unix(2000000000, 0), HttpOnly: true, Secure: true},
+	})
+	return c
+}
+
+func checkSession(t *testing.T, got, expected *Client) {
+	t.Helper()
+	if gc, ec := got.client.Jar.Cookies(domain), expected.client.Jar.Cookies(domain); !reflect.DeepEqual(gc, ec) {
+		t.Errorf("expecting cookies %v, got %v", ec, gc)
+	}
+	if !reflect.DeepEqual(got.postData, expected.postData) {
+		t.Errorf("expecting post data %v, got %v", expected.postData, got.postData)
+	}
+	if !reflect.DeepEqual(got.docIDs, expected.docIDs) {
+		t.Errorf("expecting doc IDs %v, got %v", expected.docIDs, got.docIDs)
+	}
+	if got.username != expected.username || got.usernameShort != expected.usernameShort {
+		t.Errorf("expecting username %q (%q), got %q (%q)", expected.username, expected.usernameShort, got.username, got.usernameShort)
+	}
+	if got.request != expected.request {
+		t.Errorf("expecting request %d, got %d", expected.request, got.request)
+	}
+	if gf, ef := got.Fingerprint(), expected.Fingerprint(); !reflect.DeepEqual(gf, ef) {
+		t.Errorf("expecting fingerprint %+v, got %+v", ef, gf)
+	}
+}
+
+func TestSessionBinaryRoundTrip(t *testing.T) {
+	c := newSessionClient()
+	b, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.HasPrefix(b, []byte(sessionMagic+"\x01")) {
+		t.Fatalf("expecting session header, got %q", b[:5])
+	}
+	var d Client
+	if err := d.UnmarshalBinary(b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkSession(t, &d, c)
+	if err := d.UnmarshalBinary(b); err != ErrIntialised {
+		t.Errorf("expecting ErrIntialised, got %v", err)
+	}
+}
+
+func TestSessionUnknownTags(t *testing.T) {
+	sw := sessionWriter{
+		buf: append([]byte(sessionMagic), sessionVersion),
+	}
+	sw.field(tagUsername, func(f *sessionWriter) {
+		f.string("Me Self")
+	})
+	sw.field(tagCachedUser+100, func(f *sessionWriter) {
+		f.string("from a later version")
+		f.uvarint(7)
+	})
+	sw.field(tagFingerprint, func(f *sessionWriter) {
+		f.field(tagFingerprintLSD, func(f *sessionWriter) {
+			f.raw("lsd")
+		})
+		f.field(tagFingerprintLSD+100, func(f *sessionWriter) {
+			f.raw("unknown")
+		})
+		f.uvarint(tagEnd)
+	})
+	sw.field(tagRequest, func(f *sessionWriter) {
+		f.uvarint(7)
+	})
+	sw.uvarint(tagEnd)
+	var c Client
+	if err := c.UnmarshalBinary(sw.buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.username != "Me Self" {
+		t.Errorf("expecting username %q, got %q", "Me Self", c.username)
+	}
+	if c.request != 7 {
+		t.Errorf("expecting request 7, got %d", c.request)
+	}
+	if c.fingerprint.LSD != "lsd" {
+		t.Errorf("expecting LSD %q, got %q", "lsd", c.fingerprint.LSD)
+	}
+}
+
+func baselineSession(c *Client) []byte {
+	var buf bytes.Buffer
+	w := byteio.StickyLittleEndianWriter{Writer: &buf}
+	str := func(s string) {
+		w.WriteUint32(uint32(len(s)))
+		w.Write([]byte(s))
+	}
+	flag := func(b bool) {
+		if b {
+			w.WriteUint8(1)
+		} else {
+			w.WriteUint8(0)
+		}
+	}
+	cookies := c.client.Jar.Cookies(domain)
+	w.WriteUint8(uint8(len(cookies)))
+	for _, cookie := range cookies {
+		str(cookie.Name)
+		str(cookie.Value)
+		str(cookie.Path)
+		str(cookie.Domain)
+		w.WriteInt64(int64(cookie.MaxAge))
+		flag(cookie.HttpOnly)
+		flag(cookie.Secure)
+		b, _ := cookie.Expires.MarshalBinary()
+		w.WriteUint8(uint8(len(b)))
+		w.Write(b)
+	}
+	w.WriteUint8(uint8(len(c.postData)))
+	for key := range c.postData {
+		str(key)
+		str(c.postData.Get(key))
+	}
+	w.WriteUint8(uint8(len(c.docIDs)))
+	for key, id := range c.docIDs {
+		str(key)
+		str(id)
+	}
+	str(c.username)
+	str(c.usernameShort)
+	w.WriteUint64(c.request)
+	return buf.Bytes()
+}
+
+func TestSessionV0(t *testing.T) {
+	expected := newSessionClient()
+	old := newSessionClient()
+	old.fingerprint.setValues(old.postData)
+	var c Client
+	if err := c.UnmarshalBinary(baselineSession(old)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkSession(t, &c, expected)
+	b, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var d Client
+	if err := d.UnmarshalBinary(b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkSession(t, &d, expected)
+}
+
+func TestSessionTrailingData(t *testing.T) {
+	expected := newSessionClient()
+	current, err := expected.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	old := newSessionClient()
+	old.fingerprint.setValues(old.postData)
+	for n, session := range [][]byte{current, baselineSession(old)} {
+		for m, wrap := range []func(io.Reader) io.Reader{
+			func(r io.Reader) io.Reader { return r },
+			func(r io.Reader) io.Reader { return struct{ io.Reader }{r} },
+		} {
+			buf := bytes.NewBuffer(append(append([]byte{}, session...), "TRAILER"...))
+			var c Client
+			if err := c.UnmarshalBinaryReader(wrap(buf)); err != nil {
+				t.Errorf("test %d.%d: unexpected error: %s", n+1, m+1, err)
+				continue
+			}
+			checkSession(t, &c, expected)
+			if rest := buf.String(); rest != "TRAILER" {
+				t.Errorf("test %d.%d: expecting trailing data %q, got %q", n+1, m+1, "TRAILER", rest)
+			}
+		}
+	}
+}
+
+func TestSessionInvalid(t *testing.T) {
+	valid, err := newSessionClient().MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for n, test := range []struct {
+		Data string
+		Err  error
+	}{
+		{Data: sessionMagic + "\x00", Err: ErrSessionVersion},
+		{Data: sessionMagic + "\x02", Err: ErrSessionVersion},
+		{Data: sessionMagic + "\x01", Err: io.ErrUnexpectedEOF},
+		{Data: string(valid[:len(valid)/2]), Err: io.ErrUnexpectedEOF},
+		{Data: sessionMagic + "\x01\x04\x81\x80\x80\x08", Err: ErrInvalidSession},
+		{Data: sessionMagic + "\x01\x01\x02\x05\x00", Err: ErrInvalidSession},
+	} {
+		var c Client
+		if err := c.UnmarshalBinary([]byte(test.Data)); !errors.Is(err, test.Err) {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		}
+	}
+}
+
+func TestSessionFieldLimit(t *testing.T) {
+	c := newSessionClient()
+	c.username = strings.Repeat("a", maxSessionField+1)
+	if _, err := c.MarshalBinary(); err != ErrSessionTooLarge {
+		t.Fatalf("expecting ErrSessionTooLarge, got %v", err)
+	}
+	c.username = strings.Repeat("a", maxSessionField-8)
+	b, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var d Client
+	if err := d.UnmarshalBinary(b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkSession(t, &d, c)
+}
+
+func cacheThread(id string, folder Folder, updated time.Time, snippet string) Thread {
+	t := Thread{
+		ID:                       id,
+		Name:                     "Thread " + id,
+		Type:                     ThreadGroup,
+		Folder:                   folder,
+		MutedUntil:               updated.Add(time.Hour),
+		Participants:             []string{"100", "200"},
+		ParticipantCustomisation: map[string]string{"200": "al"},
+		UnreadCount:              1,
+		MessageCount:             10,
+		Updated:                  updated,
+	}
+	t.LastMessage.Sender = "200"
+	t.LastMessage.Snippet = snippet
+	t.LastMessage.Time = updated
+	return t
+}
+
+func newCachedClient(threads int, snippet string) *Client {
+	c := newSessionClient()
+	base := time.Unix(1600000000, 0).UTC()
+	c.synced = base.Add(time.Minute)
+	c.threads = make(map[string]Thread, threads)
+	for n := 0; n < threads; n++ {
+		id := strconv.Itoa(1000 + n)
+		c.threads[id] = cacheThread(id, Folder(n%4), base.Add(time.Duration(n)*time.Second), snippet)
+	}
+	c.users = map[string]User{
+		"200": {ID: "200", Name: "Al Bee", ShortName: "Al", Username: "al.bee", Gender: GenderMale, Updated: base, Active: true, LastActive: base, Blocked: true},
+		"300": {ID: "300", Name: "Cy Dee", ShortName: "Cy", Gender: GenderFemale, Updated: base},
+	}
+	return c
+}
+
+func unmarshalSession(name string, b []byte) (*Client, error) {
+	var c Client
+	if name == "JSON" {
+		return &c, c.UnmarshalJSON(b)
+	}
+	return &c, c.UnmarshalBinary(b)
+}
+
+func TestSessionCache(t *testing.T) {
+	c := newCachedClient(8, "hello")
+	WithSessionCache()(c)
+	for _, test := range []struct {
+		Name    string
+		Marshal func() ([]byte, error)
+	}{
+		{"JSON", c.MarshalJSON},
+		{"binary", c.MarshalBinary},
+	} {
+		b, err := test.Marshal()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.Name, err)
+		}
+		d, err := unmarshalSession(test.Name, b)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.Name, err)
+		}
+		checkSession(t, d, c)
+		if !reflect.DeepEqual(d.threads, c.threads) {
+			t.Errorf("%s: expecting threads %+v, got %+v", test.Name, c.threads, d.threads)
+		}
+		if !reflect.DeepEqual(d.users, c.users) {
+			t.Errorf("%s: expecting users %+v, got %+v", test.Name, c.users, d.users)
+		}
+		if !d.LastSync().Equal(c.LastSync()) {
+			t.Errorf("%s: expecting last sync %s, got %s", test.Name, c.LastSync(), d.LastSync())
+		}
+		if !d.cache {
+			t.Errorf("%s: expecting cache to remain enabled", test.Name)
+		}
+	}
+}
+
+func TestSessionNoCache(t *testing.T) {
+	c := newCachedClient(8, "hello")
+	for _, test := range []struct {
+		Name    string
+		Marshal func() ([]byte, error)
+	}{
+		{"JSON", c.MarshalJSON},
+		{"binary", c.MarshalBinary},
+	} {
+		b, err := test.Marshal()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.Name, err)
+		}
+		if bytes.Contains(b, []byte("Al Bee")) || bytes.Contains(b, []byte("Thread 1000")) {
+			t.Errorf("%s: session contains cached data", test.Name)
+		}
+		d, err := unmarshalSession(test.Name, b)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.Name, err)
+		}
+		if len(d.threads) != 0 || len(d.users) != 0 || !d.LastSync().IsZero() || d.cache {
+			t.Errorf("%s: expecting no cache, got %d threads, %d users, synced %s", test.Name, len(d.threads), len(d.users), d.LastSync())
+		}
+	}
+}
+
+func TestSessionNestedCache(t *testing.T) {
+	c := newCachedClient(3, "hello")
+	sw := sessionWriter{
+		buf: append([]byte(sessionMagic), sessionVersion),
+	}
+	sw.field(tagCache, func(f *sessionWriter) {
+		f.field(tagCacheSynced, func(f *sessionWriter) {
+			f.time(c.synced)
+		})
+		for _, thread := range c.threads {
+			f.field(tagCacheThread, func(f *sessionWriter) {
+				f.thread(thread)
+			})
+		}
+		for _, user := range c.users {
+			f.field(tagCacheUser, func(f *sessionWriter) {
+				f.user(user)
+			})
+		}
+		f.uvarint(tagEnd)
+	})
+	sw.uvarint(tagEnd)
+	var d Client
+	if err := d.UnmarshalBinary(sw.buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(d.threads, c.threads) {
+		t.Errorf("expecting threads %+v, got %+v", c.threads, d.threads)
+	}
+	if !reflect.DeepEqual(d.users, c.users) {
+		t.Errorf("expecting users %+v, got %+v", c.users, d.users)
+	}
+	if !d.LastSync().Equal(c.LastSync()) {
+		t.Errorf("expecting last sync %s, got %s", c.LastSync(), d.LastSync())
+	}
+}
+
+func TestSessionLargeCache(t *testing.T) {
+	snippet := strings.Repeat("x", 1000)
+	c := newCachedClient(4000, snippet)
+	WithSessionCache()(c)
+	b, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	d, err := unmarshalSession("binary", b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(d.threads) != len(c.threads) {
+		t.Errorf("expecting %d threads, got %d", len(c.threads), len(d.threads))
+	}
+	c = newCachedClient(maxSessionField/len(snippet)+1, snippet)
+	WithSessionCache()(c)
+	c.threads["huge"] = cacheThread("huge", FolderInbox, time.Unix(1600000000, 0).UTC(), strings.Repeat("x", maxSessionField+1))
+	for _, test := range []struct {
+		Name    string
+		Marshal func() ([]byte, error)
+		Threads int
+	}{
+		{"JSON", c.MarshalJSON, len(c.threads)},
+		{"binary", c.MarshalBinary, len(c.threads) - 1},
+	} {
+		b, err := test.Marshal()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.Name, err)
+		}
+		d, err := unmarshalSession(test.Name, b)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.Name, err)
+		}
+		checkSession(t, d, c)
+		if len(d.threads) != test.Threads {
+			t.Errorf("%s: expecting %d threads, got %d", test.Name, test.Threads, len(d.threads))
+		}
+		if !reflect.DeepEqual(d.threads["1000"], c.threads["1000"]) {
+			t.Errorf("%s: expecting thread %+v, got %+v", test.Name, c.threads["1000"], d.threads["1000"])
+		}
+		if !reflect.DeepEqual(d.users, c.users) {
+			t.Errorf("%s: expecting users %+v, got %+v", test.Name, c.users, d.users)
+		}
+	}
+}
+
+func TestSessionMuteForever(t *testing.T) {
+	c := newCachedClient(2, "hello")
+	WithSessionCache()(c)
+	thread := c.threads["1000"]
+	thread.MutedUntil = muteToTime(-1)
+	c.threads["1000"] = thread
+	for _, test := range []struct {
+		Name    string
+		Marshal func() ([]byte, error)
+	}{
+		{"JSON", c.MarshalJSON},
+		{"binary", c.MarshalBinary},
+	} {
+		b, err := test.Marshal()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.Name, err)
+		}
+		d, err := unmarshalSession(test.Name, b)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.Name, err)
+		}
+		if until := d.threads["1000"].MutedUntil; !until.Equal(MuteForever) {
+			t.Errorf("%s: expecting thread muted forever, got %s", test.Name, until)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package messengertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"vimagination.zapto.org/messenger"
+)
+
+func TestBatch(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	log := newRequestLog(s)
+	c, err := s.Login(messenger.WithTransport(log), messenger.WithRateLimit(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.take("")
+	r, err := c.Batch().Thread("200").Thread("404").ThreadList(messenger.FolderPending).Thread("999").Do(context.Background())
+	var errs messenger.BatchErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Query != "Thread(404)" || !messenger.IsNotFound(errs[0]) {
+		t.Fatalf("expecting not found error for Thread(404), got %v", err)
+	} else if r.Successful != 3 {
+		t.Fatalf("expecting 3 successful queries, got %d", r.Successful)
+	} else if ms := r.Threads["200"]; len(ms) != 2 || ms[0].Message != "hi" || ms[1].Message != "yo" {
+		t.Fatalf("unexpected messages: %+v", ms)
+	} else if ms := r.Threads["999"]; len(ms) != 1 || ms[0].Message != "hey all" {
+		t.Fatalf("unexpected messages: %+v", ms)
+	} else if pending := c.Threads(messenger.FolderPending); len(pending) != 1 || pending[0].ID != "300" {
+		t.Fatalf("unexpected pending threads: %+v", pending)
+	}
+	if requests := log.take("/api/graphqlbatch"); len(requests) != 1 {
+		t.Fatalf("expecting 1 batch request, got %d", len(requests))
+	}
+	b := c.Batch()
+	for i := 0; i < 25; i++ {
+		b.Thread("200")
+	}
+	if r, err = b.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if r.Successful != 25 {
+		t.Fatalf("expecting 25 successful queries, got %d", r.Successful)
+	}
+	requests := log.take("/api/graphqlbatch")
+	if len(requests) != 2 {
+		t.Fatalf("expecting 2 batch requests, got %d", len(requests))
+	}
+	for n, size := range [...]int{20, 5} {
+		var queries map[string]json.RawMessage
+		if err = json.Unmarshal([]byte(requests[n].Form.Get("queries")), &queries); err != nil {
+			t.Fatal(err)
+		} else if len(queries) != size {
+			t.Fatalf("request %d: expecting %d queries, got %d", n+1, size, len(queries))
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package messengertest
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"vimagination.zapto.org/messenger"
+)
+
+type User struct {
+	ID, Name, ShortName, Username string
+	Gender                        messenger.Gender
+	Active                        bool
+	LastActive                    time.Time
+}
+
+type Message struct {
+	Sender, Text string
+	Time         time.Time
+}
+
+type Thread struct {
+	ID           string
+	Name         string
+	Group        bool
+	Folder       messenger.Folder
+	Participants []string
+	Nicknames    map[string]string
+	MuteUntil    int64
+	Unread       int
+	Messages     []Message
+}
+
+func (t *Thread) updated() time.Time {
+	if len(t.Messages) == 0 {
+		return time.Unix(0, 0)
+	}
+	return t.Messages[len(t.Messages)-1].Time
+}
+
+func (s *Server) AddUser(u User) {
+	s.mu.Lock()
+	s.users[u.ID] = u
+	s.mu.Unlock()
+}
+
+func (s *Server) User(id string) (User, bool) {
+	s.mu.Lock()
+	u, ok := s.users[id]
+	s.mu.Unlock()
+	return u, ok
+}
+
+func (s *Server) Blocked(id string) bool {
+	s.mu.Lock()
+	blocked := s.blocked[id]
+	s.mu.Unlock()
+	return blocked
+}
+
+func (s *Server) AddThread(t Thread) {
+	t.Participants = append([]string(nil), t.Participants...)
+	t.Messages = append([]Message(nil), t.Messages...)
+	sort.SliceStable(t.Messages, func(i, j int) bool {
+		return t.Messages[i].Time.Before(t.Messages[j].Time)
+	})
+	nicknames := make(map[string]string, len(t.Nicknames))
+	for id, nick := range t.Nicknames {
+		nicknames[id] = nick
+	}
+	t.Nicknames = nicknames
+	s.mu.Lock()
+	s.threads[t.ID] = &t
+	s.mu.Unlock()
+}
+
+func (s *Server) AddMessage(threadID string, m Message) bool {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.threads[threadID]
+	if !ok {
+		return false
+	}
+	n := sort.Search(len(t.Messages), func(i int) bool {
+		return t.Messages[i].Time.After(m.Time)
+	})
+	t.Messages = append(t.Messages, Message{})
+	copy(t.Messages[n+1:], t.Messages[n:])
+	t.Messages[n] = m
+	if m.Sender != s.self.ID {
+		t.Unread++
+	}
+	return true
+}
+
+func (s *Server) Thread(id string) (Thread, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.threads[id]
+	if !ok {
+		return Thread{}, false
+	}
+	c := *t
+	c.Participants = append([]string(nil), t.Participants...)
+	c.Messages = append([]Message(nil), t.Messages...)
+	c.Nicknames = make(map[string]string, len(t.Nicknames))
+	for id, nick := range t.Nicknames {
+		c.Nicknames[id] = nick
+	}
+	return c, true
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func timestamp(t time.Time) string {
+	return strconv.FormatInt(millis(t), 10)
+}
+
+func folderTag(f messenger.Folder) string {
+	switch f {
+	case messenger.FolderPending:
+		return "PENDING"
+	case messenger.FolderOther:
+		return "OTHER"
+	case messenger.FolderArchived:
+		return "ARCHIVED"
+	default:
+		return "INBOX"
+	}
+}
+
+func genderName(g messenger.Gender) string {
+	switch g {
+	case messenger.GenderMale:
+		return "MALE"
+	case messenger.GenderFemale:
+		return "FEMALE"
+	default:
+		return "NEUTER"
+	}
+}
+
+func genderID(g messenger.Gender) int {
+	switch g {
+	case messenger.GenderFemale:
+		return 1
+	case messenger.GenderMale:
+		return 2
+	default:
+		return 0
+	}
+}
+
+type messagingActor struct {
+	ID        string `json:"id"`
+	Type      string `json:"__typename"`
+	Name      string `json:"name,omitempty"`
+	Gender    string `json:"gender,omitempty"`
+	URL       string `json:"url,omitempty"`
+	ShortName string `json:"short_name,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Blocked   *bool  `json:"is_message_blocked_by_viewer,omitempty"`
+}
+
+type actorNode struct {
+	MessagingActor messagingActor `json:"messaging_actor"`
+}
+
+type threadKey struct {
+	ThreadFBID  string  `json:"thread_fbid"`
+	OtherUserID *string `json:"other_user_id"`
+}
+
+type lastMessage struct {
+	Snippet       string    `json:"snippet"`
+	MessageSender actorNode `json:"message_sender"`
+	Timestamp     string    `json:"timestamp_precise"`
+}
+
+type customisation struct {
+	ID       string `json:"participant_id"`
+	Nickname string `json:"nickname"`
+}
+
+type threadNode struct {
+	ThreadKey   threadKey `json:"thread_key"`
+	Name        string    `json:"name"`
+	Folder      string    `json:"folder"`
+	MuteUntil   *int64    `json:"mute_until"`
+	LastMessage struct {
+		Nodes []lastMessage `json:"nodes"`
+	} `json:"last_message"`
+	UnreadCount   int    `json:"unread_count"`
+	MessagesCount int    `json:"messages_count"`
+	UpdatedTime   string `json:"updated_time_precise"`
+	Customisation struct {
+		Participants []customisation `json:"participant_customizations"`
+	} `json:"customization_info"`
+	ThreadType   string `json:"thread_type"`
+	Participants struct {
+		Nodes []actorNode `json:"nodes"`
+	} `json:"all_participants"`
+}
+
+type threadListData struct {
+	Viewer struct {
+		MessageThreads struct {
+			Nodes []threadNode `json:"nodes"`
+		} `json:"message_threads"`
+	} `json:"viewer"`
+}
+
+func (s *Server) threadList(folder string, limit int, before int64) threadListData {
+	var threads []*Thread
+	for _, t := range s.threads {
+		if folderTag(t.Folder) != folder {
+			continue
+		}
+		if before > 0 && millis(t.updated()) >= before {
+			continue
+		}
+		threads = append(threads, t)
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].updated().After(threads[j].updated())
+	})
+	if limit > 0 && len(threads) > limit {
+		threads = threads[:limit]
+	}
+	var list threadListData
+	list.Viewer.MessageThreads.Nodes = make([]threadNode, 0, len(threads))
+	for _, t := range threads {
+		list.Viewer.MessageThreads.Nodes = append(list.Viewer.MessageThreads.Nodes, s.threadNode(t))
+	}
+	return list
+}
+
+func (s *Server) threadNode(t *Thread) threadNode {
+	node := threadNode{
+		ThreadKey: threadKey{
+			ThreadFBID: t.ID,
+		},
+		Name:          t.Name,
+		Folder:        folderTag(t.Folder),
+		UnreadCount:   t.Unread,
+		MessagesCount: len(t.Messages),
+		UpdatedTime:   timestamp(t.updated()),
+		ThreadType:    "GROUP",
+	}
+	if !t.Group {
+		node.ThreadType = "ONE_TO_ONE"
+		other := t.ID
+		node.ThreadKey.OtherUserID = &other
+	}
+	if t.MuteUntil != 0 {
+		mute := t.MuteUntil
+		node.MuteUntil = &mute
+	}
+	if len(t.Messages) > 0 {
+		m := t.Messages[len(t.Messages)-1]
+		node.LastMessage.Nodes = []lastMessage{
+			{
+				Snippet:       m.Text,
+				MessageSender: actorNode{MessagingActor: messagingActor{ID: m.Sender, Type: "User"}},
+				Timestamp:     timestamp(m.Time),
+			},
+		}
+	}
+	for _, id := range t.Participants {
+		u := s.users[id]
+		blocked := s.blocked[id]
+		node.Participants.Nodes = append(node.Participants.Nodes, actorNode{
+			MessagingActor: messagingActor{
+				ID:        id,
+				Type:      "User",
+				Name:      u.Name,
+				Gender:    genderName(u.Gender),
+				URL:       "https://www.facebook.com/" + id,
+				ShortName: u.ShortName,
+				Username:  u.Username,
+				Blocked:   &blocked,
+			},
+		})
+	}
+	for id, nick := range t.Nicknames {
+		node.Customisation.Participants = append(node.Customisation.Participants, customisation{
+			ID:       id,
+			Nickname: nick,
+		})
+	}
+	return node
+}
+
+type messageNode struct {
+	TypeName string `json:"__typename"`
+	Sender   struct {
+		ID string `json:"id"`
+	} `json:"message_sender"`
+	Timestamp string `json:"timestamp_precise"`
+	Unread    bool   `json:"unread"`
+	Message   struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Snippet string `json:"snippet"`
+}
+
+type messagesData struct {
+	MessageThread struct {
+		UnreadCount  int    `json:"unread_count"`
+		MessageCount int    `json:"message_count"`
+		UpdatedTime  string `json:"updated_time_precise"`
+		Messages     struct {
+			PageInfo struct {
+				HasPreviousPage bool `json:"has_previous_page"`
+			} `json:"page_info"`
+			Nodes []messageNode `json:"nodes"`
+		} `json:"messages"`
+	} `json:"message_thread"`
+}
+
+func (s *Server) messages(t *Thread, limit int, before int64) messagesData {
+	msgs := t.Messages
+	if before > 0 {
+		msgs = msgs[:sort.Search(len(msgs), func(i int) bool {
+			return millis(msgs[i].Time) >= before
+		})]
+	}
+	var data messagesData
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+		data.MessageThread.Messages.PageInfo.HasPreviousPage = true
+	}
+	data.MessageThread.UnreadCount = t.Unread
+	data.MessageThread.MessageCount = len(t.Messages)
+	data.MessageThread.UpdatedTime = timestamp(t.updated())
+	data.MessageThread.Messages.Nodes = make([]messageNode, len(msgs))
+	for n, m := range msgs {
+		node := &data.MessageThread.Messages.Nodes[n]
+		node.TypeName = "UserMessage"
+		node.Sender.ID = m.Sender
+		node.Timestamp = timestamp(m.Time)
+		node.Unread = n >= len(msgs)-t.Unread
+		node.Message.Text = m.Text
+		node.Snippet = m.Text
+	}
+	return data
+}
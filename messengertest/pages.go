@@ -0,0 +1,178 @@
+package messengertest
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+
+	"vimagination.zapto.org/messenger"
+)
+
+const (
+	DocIDThreadList      = "1475048592613093"
+	DocIDThread          = "1498317363570230"
+	DocIDSearch          = "1752917158130263"
+	DocIDParticipants    = "1616405465137473"
+	DocIDSharedMedia     = "1769447806493773"
+	DocIDMessageReaction = "1491398900900362"
+	DocIDThreadName      = "2147548778893470"
+
+	resourceBase     = "https://static.xx.fbcdn.net"
+	resourcePath     = "/rsrc.php/v3/messenger.js"
+	resourceURL      = resourceBase + resourcePath
+	moreResourcePath = "/rsrc.php/v3/messenger-more.js"
+	moreResourceURL  = resourceBase + moreResourcePath
+)
+
+var (
+	docIDs = [...]string{DocIDThreadList, DocIDThread, DocIDSearch, DocIDParticipants, DocIDSharedMedia, DocIDMessageReaction, DocIDThreadName}
+
+	loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+	<head><title>Messenger</title></head>
+	<body>
+		<script>bigPipe.onPageletArrive({{.Pagelet}});</script>
+		<form id="login_form" action="/login/password/" method="post">
+			<input type="hidden" name="lsd" value="{{.LSD}}" />
+			<input type="hidden" name="initial_request_id" value="{{.RequestID}}" />
+			<input type="text" name="email" value="" />
+			<input type="password" name="pass" />
+			<button type="submit" name="login" value="1">Continue</button>
+		</form>
+	</body>
+</html>`))
+	checkpointPage = template.Must(template.New("checkpoint").Parse(`<!DOCTYPE html>
+<html>
+	<head><title>Enter Login Code</title></head>
+	<body>
+		<form action="/checkpoint/" method="post">
+			<input type="hidden" name="nh" value="{{.}}" />
+			<input type="text" name="approvals_code" value="" />
+			<input type="submit" name="submit[Continue]" value="Continue" />
+		</form>
+	</body>
+</html>`))
+	homePage = template.Must(template.New("home").Parse(`<!DOCTYPE html>
+<html>
+	<head><title>Messenger</title></head>
+	<body>
+		<script>requireLazy(["Bootloader"], function(b) {b.setResourceMap({{.ResourceMap}});b.enableBootload({{.Bootloads}});});</script>
+		<script>require("ServerJSDefine").handleDefines({{.Defines}});</script>
+		<script>new (require("ServerJS"))().handleServerJS({{.ServerJS}});</script>
+		<div id="root"></div>
+	</body>
+</html>`))
+)
+
+const (
+	resourceScript = `__d("MessengerThreadlistWebGraphQLQuery",[],(function(a,b,c,d,e,f){e.exports={__getDocID:function(){return"` + DocIDThreadList + `"}}}),null);
+__d("MessengerThreadsWebGraphQLQuery",[],(function(a,b,c,d,e,f){e.exports={__getDocID:function(){return"` + DocIDThread + `"}}}),null);
+`
+	moreResourceScript = `__d("MessengerSearchWebGraphQLQuery",[],(function(a,b,c,d,e,f){e.exports={__getDocID:function(){return"` + DocIDSearch + `"}}}),null);
+__d("MessengerParticipantsWebGraphQLQuery",[],(function(a,b,c,d,e,f){e.exports={__getDocID:function(){return"` + DocIDParticipants + `"}}}),null);
+__d("MessengerSharedMediaWebGraphQLQuery",[],(function(a,b,c,d,e,f){e.exports={__getDocID:function(){return"` + DocIDSharedMedia + `"}}}),null);
+__d("MessengerMessageReactionWebGraphQLMutation",[],(function(a,b,c,d,e,f){e.exports={__getDocID:function(){return"` + DocIDMessageReaction + `"}}}),null);
+__d("MessengerThreadNameWebGraphQLMutation",[],(function(a,b,c,d,e,f){e.exports={__getDocID:function(){return"` + DocIDThreadName + `"}}}),null);
+`
+)
+
+func jsJSON(v interface{}) template.JS {
+	buf, _ := json.Marshal(v)
+	return template.JS(buf)
+}
+
+func writeLoginPage(w io.Writer, datr, lsd string) error {
+	return loginPage.Execute(w, struct {
+		Pagelet        template.JS
+		LSD, RequestID string
+	}{
+		Pagelet: jsJSON(map[string]interface{}{
+			"jsmods": map[string]interface{}{
+				"require": [][]interface{}{
+					{"CookieCore", "set", []interface{}{}, []interface{}{"datr", datr, 63072000000, "/", false, true}},
+				},
+			},
+		}),
+		LSD:       lsd,
+		RequestID: token(),
+	})
+}
+
+func writeCheckpointPage(w io.Writer, nh string) error {
+	return checkpointPage.Execute(w, nh)
+}
+
+func (s *Server) writeHomePage(w io.Writer) error {
+	s.mu.Lock()
+	threads := s.threadList(folderTag(messenger.FolderInbox), 20, 0)
+	dtsg := s.dtsg
+	s.mu.Unlock()
+	return homePage.Execute(w, struct {
+		ResourceMap, Bootloads, Defines, ServerJS template.JS
+	}{
+		ResourceMap: jsJSON(map[string]interface{}{
+			"mTlF": map[string]string{
+				"type": "js",
+				"src":  resourceURL,
+				"p":    ":1843,2017",
+			},
+			"mMor": map[string]string{
+				"type": "js",
+				"src":  moreResourceURL,
+			},
+			"sCss": map[string]string{
+				"type": "css",
+				"src":  "https://static.xx.fbcdn.net/rsrc.php/v3/messenger.css",
+			},
+		}),
+		Bootloads: jsJSON(map[string]interface{}{
+			"MessengerGraphQLThreadlistFetcher.bs": map[string]interface{}{
+				"resources": []string{"mTlF", "sCss"},
+			},
+			"MessengerGraphQLSearchFetcher.bs": map[string]interface{}{
+				"resources": []string{"mMor"},
+			},
+			"MessengerGraphQLMutations.bs": map[string]interface{}{
+				"resources": []string{"mMor", "sCss"},
+			},
+		}),
+		Defines: jsJSON([][]interface{}{
+			{"CurrentUserInitialData", []interface{}{}, map[string]string{
+				"USER_ID":    s.self.ID,
+				"NAME":       s.self.Name,
+				"SHORT_NAME": s.self.ShortName,
+			}, 12},
+			{"DTSGInitialData", []interface{}{}, map[string]string{
+				"token": dtsg,
+			}, 258},
+			{"SiteData", []interface{}{}, map[string]interface{}{
+				"server_revision": revision,
+				"pkg_cohort_key":  "__pc",
+				"pkg_cohort":      "PHASED:DEFAULT",
+				"be_key":          "__be",
+				"be_mode":         -1,
+				"haste_session":   "19876.HYP:messengerdotcom_pkg.2.1.0.0",
+				"pr":              1,
+			}, 317},
+			{"LSD", []interface{}{}, map[string]string{
+				"token": s.lsdToken,
+			}, 323},
+			{"SprinkleConfig", []interface{}{}, map[string]string{
+				"param_name": "jazoest",
+			}, 2111},
+			{"MqttWebConfig", []interface{}{}, map[string]interface{}{
+				"endpoint": "wss://edge-chat.messenger.com/chat?region=prn",
+				"appID":    219994525426954,
+			}, 1129},
+		}),
+		ServerJS: jsJSON(map[string]interface{}{
+			"require": [][]interface{}{
+				{"MessengerMount", "main", []interface{}{}, []interface{}{nil, map[string]interface{}{
+					"graphqlPayload": map[string]interface{}{
+						"thread_list": threads,
+					},
+				}}},
+			},
+		}),
+	})
+}
@@ -0,0 +1,91 @@
+package messengertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"vimagination.zapto.org/messenger"
+)
+
+func TestPresenceKeepsUnreported(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	c, err := s.Login(messenger.WithRateLimit(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := time.Unix(1600000000, 0)
+	if _, err = c.Presence(); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := c.User("200"); !u.Active || !u.LastActive.Equal(last) {
+		t.Fatalf("unexpected presence: %+v", u)
+	}
+	s.AddUser(User{ID: "200", Name: "Al Bee", ShortName: "Al"})
+	p, err := c.Presence("200", "400")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := p["200"]; !u.Active || !u.LastActive.Equal(last) {
+		t.Errorf("unreported user changed: %+v", u)
+	}
+	if u, _ := c.User("200"); !u.Active || !u.LastActive.Equal(last) {
+		t.Errorf("unreported user changed: %+v", u)
+	}
+	if _, ok := p["400"]; ok {
+		t.Error("unknown user returned")
+	}
+	if _, ok := c.User("400"); ok {
+		t.Error("unknown user stored")
+	}
+	s.AddUser(User{ID: "200", Name: "Al Bee", ShortName: "Al", LastActive: last.Add(time.Hour)})
+	if _, err = c.Presence(); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := c.User("200"); u.Active || !u.LastActive.Equal(last.Add(time.Hour)) {
+		t.Errorf("expecting inactive user last seen at %s, got %+v", last.Add(time.Hour), u)
+	}
+}
+
+func TestWatchPresence(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	c, err := s.Login(messenger.WithRateLimit(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan messenger.User, 10)
+	cancelSub := c.SubscribePresence(func(u messenger.User) {
+		updates <- u
+	})
+	defer cancelSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WatchPresence(ctx, 10*time.Millisecond)
+	}()
+	timeout := time.After(5 * time.Second)
+	for waiting := true; waiting; {
+		select {
+		case u := <-updates:
+			waiting = u.ID != "200" || !u.Active
+		case <-timeout:
+			t.Fatal("timed out waiting for initial presence")
+		}
+	}
+	s.AddUser(User{ID: "300", Name: "Cy Dee", ShortName: "Cy", Active: true})
+	for waiting := true; waiting; {
+		select {
+		case u := <-updates:
+			waiting = u.ID != "300" || !u.Active
+		case <-timeout:
+			t.Fatal("timed out waiting for presence update")
+		}
+	}
+	cancel()
+	if err = <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expecting context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package messengertest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vimagination.zapto.org/messenger"
+)
+
+type failer struct {
+	rt   http.RoundTripper
+	mu   sync.Mutex
+	path string
+	n    int
+}
+
+func (f *failer) fail(path string, n int) {
+	f.mu.Lock()
+	f.path, f.n = path, n
+	f.mu.Unlock()
+}
+
+func (f *failer) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	fail := r.URL.Path == f.path && f.n > 0
+	if fail {
+		f.n--
+	}
+	f.mu.Unlock()
+	if fail {
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    r,
+		}, nil
+	}
+	return f.rt.RoundTrip(r)
+}
+
+func TestRetry(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	f := &failer{rt: s.Transport()}
+	c, err := s.Login(messenger.WithTransport(f), messenger.WithRateLimit(0, 0), messenger.WithRetryPolicy(messenger.RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, test := range []struct {
+		Path              string
+		Fail              int
+		Call              func() error
+		Err               error
+		Requests, Retries uint64
+	}{
+		{
+			Path:     "/api/graphqlbatch",
+			Fail:     2,
+			Call:     func() error { _, err := c.GetThread("200"); return err },
+			Requests: 3,
+			Retries:  2,
+		},
+		{
+			Path:     "/api/graphqlbatch",
+			Fail:     3,
+			Call:     func() error { _, err := c.GetThread("200"); return err },
+			Err:      messenger.ErrServerError,
+			Requests: 3,
+			Retries:  2,
+		},
+		{
+			Path:     "/ajax/chat/buddy_list.php",
+			Fail:     1,
+			Call:     func() error { _, err := c.Presence(); return err },
+			Requests: 2,
+			Retries:  1,
+		},
+		{
+			Path:     "/ajax/mercury/change_archived_status.php",
+			Fail:     1,
+			Call:     func() error { return c.ArchiveThread("999") },
+			Err:      messenger.ErrServerError,
+			Requests: 1,
+		},
+		{
+			Path:     "/ajax/mercury/change_archived_status.php",
+			Call:     func() error { return c.ArchiveThread("999") },
+			Requests: 1,
+		},
+	} {
+		before := c.Stats()
+		f.fail(test.Path, test.Fail)
+		if err := test.Call(); !errors.Is(err, test.Err) || (err == nil) != (test.Err == nil) {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		}
+		after := c.Stats()
+		if requests := after.Requests - before.Requests; requests != test.Requests {
+			t.Errorf("test %d: expecting %d requests, got %d", n+1, test.Requests, requests)
+		}
+		if retries := after.Retries - before.Retries; retries != test.Retries {
+			t.Errorf("test %d: expecting %d retries, got %d", n+1, test.Retries, retries)
+		}
+	}
+	if st, _ := s.Thread("999"); st.Folder != messenger.FolderArchived {
+		t.Fatalf("thread not archived: %+v", st)
+	}
+}
+
+func TestThrottleStats(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	c, err := s.Login(messenger.WithRateLimit(50, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := c.Stats()
+	for i := 0; i < 5; i++ {
+		if _, err = c.GetThread("200"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after := c.Stats()
+	if after.Requests-before.Requests != 5 {
+		t.Fatalf("expecting 5 requests, got %d", after.Requests-before.Requests)
+	} else if after.Throttled == before.Throttled || after.ThrottledTime == before.ThrottledTime {
+		t.Fatalf("expecting throttled requests, got %+v", after)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !nootto
+// +build !nootto
+
+package messengertest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"vimagination.zapto.org/messenger"
+)
+
+func TestJSFuncDefine(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	var calls [][]string
+	c, err := s.Login(messenger.WithJSFunc("MqttWebConfig", func(args []string) string {
+		calls = append(calls, args)
+		return ""
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || len(calls[0]) != 2 || calls[0][0] != "MqttWebConfig" {
+		t.Fatalf("unexpected calls: %q", calls)
+	}
+	var config struct {
+		Endpoint string `json:"endpoint"`
+		AppID    int64  `json:"appID"`
+	}
+	if err = json.Unmarshal([]byte(calls[0][1]), &config); err != nil {
+		t.Fatal(err)
+	} else if config.Endpoint != "wss://edge-chat.messenger.com/chat?region=prn" || config.AppID != 219994525426954 {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+	if _, err = c.GetThread("200"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSFuncReserved(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	for _, name := range [...]string{"require", "setLSD", "handleDefine", "window"} {
+		if _, err := s.Login(messenger.WithJSFunc(name, func([]string) string { return "" })); err != messenger.ErrReservedJSFunc {
+			t.Errorf("%s: expecting ErrReservedJSFunc, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,572 @@
+package messengertest // import "vimagination.zapto.org/messenger/messengertest"
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vimagination.zapto.org/messenger"
+)
+
+const revision = 4402215
+
+type Server struct {
+	*httptest.Server
+	email, password string
+	self            User
+	dtsg            string
+	lsdToken        string
+
+	mu        sync.Mutex
+	twoFactor string
+	users     map[string]User
+	threads   map[string]*Thread
+	blocked   map[string]bool
+	sessions  map[string]bool
+	pending   map[string]bool
+	datr      map[string]bool
+	lsd       map[string]bool
+	docIDs    map[string]string
+	docIDGen  int
+}
+
+func NewServer(email, password string, self User) *Server {
+	s := &Server{
+		email:    email,
+		password: password,
+		self:     self,
+		dtsg:     "AQH" + token(),
+		lsdToken: token(),
+		users:    map[string]User{self.ID: self},
+		threads:  make(map[string]*Thread),
+		blocked:  make(map[string]bool),
+		sessions: make(map[string]bool),
+		pending:  make(map[string]bool),
+		datr:     make(map[string]bool),
+		lsd:      make(map[string]bool),
+		docIDs:   make(map[string]string),
+	}
+	for _, id := range docIDs {
+		s.docIDs[id] = id
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleHome)
+	mux.HandleFunc("/login", s.handleLoginPage)
+	mux.HandleFunc("/login/password/", s.handleLogin)
+	mux.HandleFunc("/checkpoint/", s.handleCheckpoint)
+	mux.HandleFunc("/logout/", s.handleLogout)
+	mux.HandleFunc(resourcePath, s.handleResource(resourceScript))
+	mux.HandleFunc(moreResourcePath, s.handleResource(moreResourceScript))
+	mux.HandleFunc("/api/graphqlbatch", s.handleBatch)
+	mux.HandleFunc("/chat/user_info/", s.ajax(s.userInfo))
+	mux.HandleFunc("/ajax/chat/buddy_list.php", s.ajax(s.buddyList))
+	mux.HandleFunc("/messaging/block_messages/", s.ajax(s.setBlocked(true)))
+	mux.HandleFunc("/messaging/unblock_messages/", s.ajax(s.setBlocked(false)))
+	mux.HandleFunc("/ajax/mercury/move_thread.php", s.ajax(s.moveThread))
+	mux.HandleFunc("/ajax/mercury/change_archived_status.php", s.ajax(s.setArchived))
+	mux.HandleFunc("/ajax/mercury/change_mute_thread.php", s.ajax(s.setMute))
+	mux.HandleFunc("/ajax/mercury/delete_thread.php", s.ajax(s.deleteThread))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) SetTwoFactorCode(code string) {
+	s.mu.Lock()
+	s.twoFactor = code
+	s.mu.Unlock()
+}
+
+func (s *Server) RotateToken() {
+	s.mu.Lock()
+	s.dtsg = "AQH" + token()
+	s.mu.Unlock()
+}
+
+func (s *Server) RotateDocIDs() {
+	s.mu.Lock()
+	s.docIDGen++
+	s.docIDs = make(map[string]string, len(docIDs))
+	for _, id := range docIDs {
+		s.docIDs[id+strconv.Itoa(s.docIDGen)] = id
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) Transport() http.RoundTripper {
+	return transport{
+		host: s.Listener.Addr().String(),
+		rt:   s.Client().Transport,
+	}
+}
+
+func (s *Server) Login(opts ...messenger.Option) (*messenger.Client, error) {
+	return messenger.Login(s.email, s.password, append([]messenger.Option{messenger.WithTransport(s.Transport())}, opts...)...)
+}
+
+type transport struct {
+	host string
+	rt   http.RoundTripper
+}
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := r.Clone(r.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = t.host
+	req.Host = r.URL.Host
+	resp, err := t.rt.RoundTrip(req)
+	if resp != nil {
+		resp.Request = r
+	}
+	return resp, err
+}
+
+func token() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+func (s *Server) loggedIn(r *http.Request) bool {
+	user, err := r.Cookie("c_user")
+	if err != nil || user.Value != s.self.ID {
+		return false
+	}
+	xs, err := r.Cookie("xs")
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	ok := s.sessions[xs.Value]
+	s.mu.Unlock()
+	return ok
+}
+
+func (s *Server) startSession(w http.ResponseWriter) {
+	xs := token()
+	s.mu.Lock()
+	s.sessions[xs] = true
+	s.mu.Unlock()
+	expires := time.Now().Add(365 * 24 * time.Hour)
+	http.SetCookie(w, &http.Cookie{Name: "c_user", Value: s.self.ID, Path: "/", Expires: expires, Secure: true})
+	http.SetCookie(w, &http.Cookie{Name: "xs", Value: xs, Path: "/", Expires: expires, Secure: true, HttpOnly: true})
+}
+
+func (s *Server) writeLoginPage(w http.ResponseWriter) {
+	datr, lsd := token(), token()
+	s.mu.Lock()
+	s.datr[datr] = true
+	s.lsd[lsd] = true
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writeLoginPage(w, datr, lsd)
+}
+
+func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.loggedIn(r) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	s.writeHomePage(w)
+}
+
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	if s.loggedIn(r) {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	s.writeLoginPage(w)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	var datr string
+	if cookie, err := r.Cookie("datr"); err == nil {
+		datr = cookie.Value
+	}
+	s.mu.Lock()
+	valid := s.datr[datr] && s.lsd[r.PostForm.Get("lsd")] && r.PostForm.Get("email") == s.email && r.PostForm.Get("pass") == s.password
+	twoFactor := s.twoFactor
+	nh := token()
+	if valid && twoFactor != "" {
+		s.pending[nh] = true
+	}
+	s.mu.Unlock()
+	if !valid {
+		s.writeLoginPage(w)
+	} else if twoFactor != "" {
+		http.Redirect(w, r, "/checkpoint/?nh="+nh, http.StatusFound)
+	} else {
+		s.startSession(w)
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+func (s *Server) handleCheckpoint(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	nh := r.Form.Get("nh")
+	s.mu.Lock()
+	pending := s.pending[nh]
+	valid := pending && r.Method == http.MethodPost && r.PostForm.Get("approvals_code") == s.twoFactor
+	if valid {
+		delete(s.pending, nh)
+	}
+	s.mu.Unlock()
+	if !pending {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	} else if valid {
+		s.startSession(w)
+		http.Redirect(w, r, "/", http.StatusFound)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writeCheckpointPage(w, nh)
+	}
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if xs, err := r.Cookie("xs"); err == nil {
+		s.mu.Lock()
+		delete(s.sessions, xs.Value)
+		s.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: "c_user", Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: "xs", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+func (s *Server) handleResource(script string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		replace := make([]string, 0, 2*len(s.docIDs))
+		for served, id := range s.docIDs {
+			replace = append(replace, id, served)
+		}
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-javascript; charset=utf-8")
+		strings.NewReplacer(replace...).WriteString(w, script)
+	}
+}
+
+type apiError struct {
+	Code        int    `json:"code"`
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+}
+
+var (
+	errNotLoggedIn    = &apiError{1357001, "Not Logged In", "Please log in to continue."}
+	errInvalidRequest = &apiError{1, "Sorry, something went wrong", "Please try closing and re-opening your browser window."}
+	errNotFound       = &apiError{1357031, "Content Not Found", "The content you requested cannot be displayed at the moment."}
+	errInvalidDocID   = &apiError{1675002, "Invalid doc_id", "The doc_id supplied is not a known persisted query."}
+)
+
+func (s *Server) authorised(r *http.Request) bool {
+	if r.Method != http.MethodPost || !s.loggedIn(r) {
+		return false
+	}
+	s.mu.Lock()
+	dtsg := s.dtsg
+	s.mu.Unlock()
+	return r.PostForm.Get("fb_dtsg") == dtsg
+}
+
+type graphQLQuery struct {
+	DocID       string          `json:"doc_id"`
+	QueryParams json.RawMessage `json:"query_params"`
+}
+
+type queryResult struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*apiError `json:"errors,omitempty"`
+}
+
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if !s.authorised(r) {
+		enc.Encode(struct {
+			Error *apiError `json:"error"`
+		}{errNotLoggedIn})
+		return
+	}
+	var queries map[string]graphQLQuery
+	if err := json.Unmarshal([]byte(r.PostForm.Get("queries")), &queries); err != nil {
+		enc.Encode(struct {
+			Error *apiError `json:"error"`
+		}{errInvalidRequest})
+		return
+	}
+	keys := make([]string, 0, len(queries))
+	for key := range queries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var successful, failed int
+	for _, key := range keys {
+		result := s.query(queries[key])
+		if len(result.Errors) > 0 {
+			failed++
+		} else {
+			successful++
+		}
+		enc.Encode(map[string]queryResult{key: result})
+	}
+	enc.Encode(struct {
+		Successful int `json:"successful_results"`
+		Failed     int `json:"error_results"`
+		Skipped    int `json:"skipped_results"`
+	}{successful, failed, 0})
+}
+
+func parseBefore(before *string) int64 {
+	if before == nil {
+		return 0
+	}
+	ms, _ := strconv.ParseInt(*before, 10, 64)
+	return ms
+}
+
+func (s *Server) query(q graphQLQuery) queryResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.docIDs[q.DocID] {
+	case DocIDThreadList:
+		var params struct {
+			Limit  int      `json:"limit"`
+			Before *string  `json:"before"`
+			Tags   []string `json:"tags"`
+		}
+		if json.Unmarshal(q.QueryParams, &params) != nil {
+			return queryResult{Errors: []*apiError{errInvalidRequest}}
+		}
+		folder := folderTag(messenger.FolderInbox)
+		if len(params.Tags) > 0 {
+			folder = params.Tags[0]
+		}
+		return queryResult{Data: s.threadList(folder, params.Limit, parseBefore(params.Before))}
+	case DocIDThread:
+		var params struct {
+			ID           string  `json:"id"`
+			MessageLimit int     `json:"message_limit"`
+			Before       *string `json:"before"`
+		}
+		if json.Unmarshal(q.QueryParams, &params) != nil {
+			return queryResult{Errors: []*apiError{errInvalidRequest}}
+		}
+		t, ok := s.threads[params.ID]
+		if !ok {
+			return queryResult{Errors: []*apiError{errNotFound}}
+		}
+		return queryResult{Data: s.messages(t, params.MessageLimit, parseBefore(params.Before))}
+	}
+	return queryResult{Errors: []*apiError{errInvalidDocID}}
+}
+
+type ajaxResponse struct {
+	Error            int         `json:"error,omitempty"`
+	ErrorSummary     string      `json:"errorSummary,omitempty"`
+	ErrorDescription string      `json:"errorDescription,omitempty"`
+	Payload          interface{} `json:"payload"`
+}
+
+func (s *Server) ajax(fn func(*http.Request) (interface{}, *apiError)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		var (
+			resp ajaxResponse
+			err  *apiError
+		)
+		if !s.authorised(r) {
+			err = errNotLoggedIn
+		} else {
+			resp.Payload, err = fn(r)
+		}
+		if err != nil {
+			resp.Error = err.Code
+			resp.ErrorSummary = err.Summary
+			resp.ErrorDescription = err.Description
+		}
+		w.Header().Set("Content-Type", "application/x-javascript; charset=utf-8")
+		io.WriteString(w, "for (;;);")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func indexed(form url.Values, name string) []string {
+	var values []string
+	for key, vals := range form {
+		if strings.HasPrefix(key, name+"[") && strings.HasSuffix(key, "]") {
+			values = append(values, vals...)
+		}
+	}
+	return values
+}
+
+type profile struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	FirstName string `json:"firstName"`
+	Vanity    string `json:"vanity"`
+	Gender    int    `json:"gender"`
+}
+
+func (s *Server) userInfo(r *http.Request) (interface{}, *apiError) {
+	profiles := make(map[string]profile)
+	s.mu.Lock()
+	for _, id := range indexed(r.PostForm, "ids") {
+		if u, ok := s.users[id]; ok {
+			profiles[id] = profile{
+				ID:        u.ID,
+				Name:      u.Name,
+				FirstName: u.ShortName,
+				Vanity:    u.Username,
+				Gender:    genderID(u.Gender),
+			}
+		}
+	}
+	s.mu.Unlock()
+	return map[string]interface{}{"profiles": profiles}, nil
+}
+
+func (s *Server) buddyList(r *http.Request) (interface{}, *apiError) {
+	type available struct {
+		Active int `json:"a"`
+	}
+	now := make(map[string]available)
+	last := make(map[string]int64)
+	s.mu.Lock()
+	for id, u := range s.users {
+		if id == s.self.ID {
+			continue
+		}
+		if u.Active {
+			now[id] = available{Active: 2}
+		}
+		if !u.LastActive.IsZero() {
+			last[id] = u.LastActive.Unix()
+		}
+	}
+	s.mu.Unlock()
+	return map[string]interface{}{
+		"buddy_list": map[string]interface{}{
+			"nowAvailableList":  now,
+			"last_active_times": last,
+		},
+	}, nil
+}
+
+func (s *Server) setBlocked(blocked bool) func(*http.Request) (interface{}, *apiError) {
+	return func(r *http.Request) (interface{}, *apiError) {
+		id := r.URL.Query().Get("fbid")
+		if id == "" {
+			return nil, errInvalidRequest
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.users[id]; !ok {
+			return nil, errNotFound
+		}
+		s.blocked[id] = blocked
+		return nil, nil
+	}
+}
+
+func getFolder(name string) (messenger.Folder, bool) {
+	for _, f := range [...]messenger.Folder{messenger.FolderInbox, messenger.FolderPending, messenger.FolderOther, messenger.FolderArchived} {
+		if strings.EqualFold(folderTag(f), name) {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Server) moveThread(r *http.Request) (interface{}, *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, ids := range r.PostForm {
+		pos := strings.IndexByte(key, '[')
+		if pos < 0 {
+			continue
+		}
+		folder, ok := getFolder(key[:pos])
+		if !ok {
+			continue
+		}
+		for _, id := range ids {
+			t, ok := s.threads[id]
+			if !ok {
+				return nil, errNotFound
+			}
+			t.Folder = folder
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) setArchived(r *http.Request) (interface{}, *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range r.PostForm {
+		if !strings.HasPrefix(key, "ids[") || !strings.HasSuffix(key, "]") {
+			continue
+		}
+		t, ok := s.threads[key[4:len(key)-1]]
+		if !ok {
+			return nil, errNotFound
+		}
+		if archived, _ := strconv.ParseBool(r.PostForm.Get(key)); archived {
+			t.Folder = messenger.FolderArchived
+		} else if t.Folder == messenger.FolderArchived {
+			t.Folder = messenger.FolderInbox
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) setMute(r *http.Request) (interface{}, *apiError) {
+	settings, err := strconv.ParseInt(r.PostForm.Get("mute_settings"), 10, 64)
+	if err != nil {
+		return nil, errInvalidRequest
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.threads[r.PostForm.Get("thread_fbid")]
+	if !ok {
+		return nil, errNotFound
+	}
+	if settings > 0 {
+		settings += time.Now().Unix()
+	}
+	t.MuteUntil = settings
+	return nil, nil
+}
+
+func (s *Server) deleteThread(r *http.Request) (interface{}, *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range indexed(r.PostForm, "ids") {
+		if _, ok := s.threads[id]; !ok {
+			return nil, errNotFound
+		}
+		delete(s.threads, id)
+	}
+	return nil, nil
+}
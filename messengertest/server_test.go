@@ -0,0 +1,215 @@
+package messengertest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"vimagination.zapto.org/messenger"
+)
+
+func newTestServer() *Server {
+	s := NewServer("me@example.com", "hunter2", User{ID: "100", Name: "Me Self", ShortName: "Me"})
+	s.AddUser(User{ID: "200", Name: "Al Bee", ShortName: "Al", Gender: messenger.GenderMale, Active: true, LastActive: time.Unix(1600000000, 0)})
+	s.AddUser(User{ID: "300", Name: "Cy Dee", ShortName: "Cy", Gender: messenger.GenderFemale})
+	base := time.Unix(1600000000, 0)
+	s.AddThread(Thread{ID: "200", Participants: []string{"100", "200"}, Messages: []Message{{Sender: "200", Text: "hi", Time: base}, {Sender: "100", Text: "yo", Time: base.Add(time.Minute)}}})
+	s.AddThread(Thread{ID: "999", Name: "Group", Group: true, Participants: []string{"100", "200", "300"}, Nicknames: map[string]string{"300": "cee"}, Messages: []Message{{Sender: "300", Text: "hey all", Time: base.Add(time.Hour)}}})
+	s.AddThread(Thread{ID: "300", Folder: messenger.FolderPending, Participants: []string{"100", "300"}, Messages: []Message{{Sender: "300", Text: "pls", Time: base}}})
+	return s
+}
+
+type loggedRequest struct {
+	Path string
+	Form url.Values
+}
+
+type requestLog struct {
+	rt       http.RoundTripper
+	mu       sync.Mutex
+	requests []loggedRequest
+}
+
+func newRequestLog(s *Server) *requestLog {
+	return &requestLog{rt: s.Transport()}
+}
+
+func (l *requestLog) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := loggedRequest{Path: r.URL.Path}
+	if r.Body != nil {
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		req.Form, _ = url.ParseQuery(string(body))
+	}
+	l.mu.Lock()
+	l.requests = append(l.requests, req)
+	l.mu.Unlock()
+	return l.rt.RoundTrip(r)
+}
+
+func (l *requestLog) take(path string) []loggedRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var matched []loggedRequest
+	for _, r := range l.requests {
+		if r.Path == path {
+			matched = append(matched, r)
+		}
+	}
+	l.requests = nil
+	return matched
+}
+
+func testSession(t *testing.T, opts ...messenger.Option) {
+	opts = append(opts, messenger.WithRateLimit(0, 0))
+	s := newTestServer()
+	defer s.Close()
+	c, err := s.Login(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateThreadList(messenger.FolderInbox, messenger.FolderPending); err != nil {
+		t.Fatal(err)
+	}
+	fp := c.Fingerprint()
+	if !reflect.DeepEqual(fp.CSR.Bits(), []uint{1843, 2017}) || !reflect.DeepEqual(fp.Dyn.Bits(), []uint{12, 258, 317, 323, 1129, 2111}) || fp.LSD != s.lsdToken || fp.HasteSession == "" || fp.PixelRatio != "1" || fp.SprinkleName != "jazoest" {
+		t.Fatalf("unexpected fingerprint: %+v", fp)
+	}
+	threads := c.Threads(messenger.FolderInbox)
+	if len(threads) != 2 || threads[0].ID != "999" || threads[0].Name != "Group" || threads[1].ID != "200" {
+		t.Fatalf("unexpected inbox: %+v", threads)
+	}
+	if pending := c.Threads(messenger.FolderPending); len(pending) != 1 || pending[0].ID != "300" {
+		t.Fatalf("unexpected pending threads: %+v", pending)
+	}
+	for _, m := range []func() ([]byte, error){c.MarshalJSON, c.MarshalBinary} {
+		b, err := m()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d messenger.Client
+		if b[0] == '{' {
+			err = d.UnmarshalJSON(b)
+		} else {
+			err = d.UnmarshalBinary(b)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.Fingerprint(), fp) {
+			t.Fatalf("fingerprint not restored: expecting %+v, got %+v", fp, d.Fingerprint())
+		}
+	}
+	ms, err := c.GetThread("200")
+	if err != nil || len(ms) != 2 || ms[1].Message != "yo" {
+		t.Fatalf("unexpected messages: %+v, %v", ms, err)
+	}
+	if _, err = c.GetThread("404"); !messenger.IsNotFound(err) {
+		t.Fatalf("expecting not found error, got %v", err)
+	}
+	if err = c.AcceptMessageRequest("300"); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := s.Thread("300"); st.Folder != messenger.FolderInbox {
+		t.Fatalf("request not accepted: %+v", st)
+	}
+	if err = c.ArchiveThread("999"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.MuteThread("200", messenger.MuteForever); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateThreadList(messenger.FolderArchived, messenger.FolderInbox); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := s.Thread("999"); st.Folder != messenger.FolderArchived {
+		t.Fatalf("thread not archived: %+v", st)
+	}
+	if st, _ := s.Thread("200"); st.MuteUntil != -1 {
+		t.Fatalf("thread not muted: %+v", st)
+	}
+	if err = c.DeleteThread("300"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Thread("300"); ok {
+		t.Fatal("thread not deleted")
+	}
+	if err = c.BlockUser("300"); err != nil {
+		t.Fatal(err)
+	} else if !s.Blocked("300") {
+		t.Fatal("user not blocked")
+	}
+	p, err := c.Presence()
+	if err != nil || !p["200"].Active {
+		t.Fatalf("unexpected presence: %+v, %v", p, err)
+	}
+	saved, _ := c.MarshalJSON()
+	if err = c.Logout(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UnmarshalJSON(saved); err != messenger.ErrLoggedOut {
+		t.Fatalf("expecting ErrLoggedOut, got %v", err)
+	}
+	if _, err = c.GetThread("200"); !errors.Is(err, messenger.ErrLoggedOut) {
+		t.Fatalf("expecting ErrLoggedOut, got %v", err)
+	}
+	c2, err := s.Login(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = c2.Logout(ctx); err == nil {
+		t.Fatal("expecting error from cancelled logout")
+	}
+	if _, err = c2.GetThread("200"); !errors.Is(err, messenger.ErrLoggedOut) {
+		t.Fatalf("expecting ErrLoggedOut, got %v", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	testSession(t)
+}
+
+func TestSessionWithoutJS(t *testing.T) {
+	testSession(t, messenger.WithoutJS())
+}
+
+func TestBadLogin(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	if _, err := messenger.Login("me@example.com", "wrong", messenger.WithTransport(s.Transport())); err != messenger.ErrInvalidLogin {
+		t.Fatalf("expecting ErrInvalidLogin, got %v", err)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	s.SetTwoFactorCode("123456")
+	c, err := s.Login()
+	var tf *messenger.TwoFactorError
+	if c != nil || !errors.Is(err, messenger.ErrNeedsTwoFactor) || !errors.As(err, &tf) {
+		t.Fatalf("expecting TwoFactorError, got %v", err)
+	}
+	if c, err = tf.Submit("000"); err != messenger.ErrInvalidTwoFactor || c != nil {
+		t.Fatalf("expecting ErrInvalidTwoFactor, got %v", err)
+	}
+	if c, err = tf.Submit("123456"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetThread("999"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SubmitTwoFactor("123456"); err != messenger.ErrNoCheckpoint {
+		t.Fatalf("expecting ErrNoCheckpoint, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package messengertest
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"vimagination.zapto.org/messenger"
+)
+
+func TestRefreshToken(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	c, err := s.Login(messenger.WithRateLimit(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	c.OnSessionRefresh(func(err error) {
+		atomic.AddInt32(&calls, 1)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	s.RotateToken()
+	if err = c.UpdateThreadList(messenger.FolderInbox); err != nil {
+		t.Fatal(err)
+	}
+	if ms, err := c.GetThread("200"); err != nil || len(ms) != 2 {
+		t.Fatalf("unexpected messages: %+v, %v", ms, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expecting 1 refresh, got %d", n)
+	}
+}
+
+func TestRefreshDocIDs(t *testing.T) {
+	for _, opts := range [][]messenger.Option{nil, {messenger.WithoutJS()}} {
+		s := newTestServer()
+		cache := messenger.DirDocIDCache(t.TempDir())
+		c, err := s.Login(append(opts, messenger.WithRateLimit(0, 0), messenger.WithDocIDCache(cache))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var calls int32
+		c.OnSessionRefresh(func(error) { atomic.AddInt32(&calls, 1) })
+		s.RotateDocIDs()
+		if ms, err := c.GetThread("200"); err != nil || len(ms) != 2 {
+			t.Fatalf("unexpected messages: %+v, %v", ms, err)
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Fatalf("expecting 1 refresh, got %d", n)
+		}
+		if c, err = s.Login(append(opts, messenger.WithRateLimit(0, 0), messenger.WithDocIDCache(cache))...); err != nil {
+			t.Fatal(err)
+		}
+		c.OnSessionRefresh(func(error) { atomic.AddInt32(&calls, 1) })
+		if err = c.UpdateThreadList(messenger.FolderInbox); err != nil {
+			t.Fatal(err)
+		} else if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Fatal("stale doc IDs kept in cache")
+		}
+		s.Close()
+	}
+}
+
+func TestDocIDCacheFingerprint(t *testing.T) {
+	for _, opts := range [][]messenger.Option{nil, {messenger.WithoutJS()}} {
+		s := newTestServer()
+		log := newRequestLog(s)
+		opts = append(opts, messenger.WithTransport(log), messenger.WithDocIDCache(messenger.DirDocIDCache(t.TempDir())))
+		c, err := s.Login(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(log.take(resourcePath)) != 1 {
+			t.Fatal("expecting resource to be fetched on first login")
+		}
+		fp := c.Fingerprint()
+		if c, err = s.Login(opts...); err != nil {
+			t.Fatal(err)
+		}
+		if len(log.take(resourcePath)) != 0 {
+			t.Fatal("expecting cached doc IDs to be used on second login")
+		}
+		if cached := c.Fingerprint(); !reflect.DeepEqual(cached.CSR.Bits(), fp.CSR.Bits()) || !reflect.DeepEqual(cached.Dyn.Bits(), fp.Dyn.Bits()) {
+			t.Fatalf("expecting fingerprint %+v, got %+v", fp, cached)
+		} else if !reflect.DeepEqual(fp.CSR.Bits(), []uint{1843, 2017}) {
+			t.Fatalf("unexpected CSR bits: %v", fp.CSR.Bits())
+		}
+		s.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package messengertest
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"vimagination.zapto.org/messenger"
+)
+
+func TestUserMerge(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	c, err := s.Login(messenger.WithRateLimit(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateThreadList(messenger.FolderInbox); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.BlockUser("200"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Presence(); err != nil {
+		t.Fatal(err)
+	}
+	c.SetUser(messenger.User{ID: "200"})
+	s.AddUser(User{ID: "200", Name: "Al Bee", ShortName: "Al", Username: "al.bee", Gender: messenger.GenderMale, Active: true, LastActive: time.Unix(1600000000, 0)})
+	if err = c.RefreshUsers(0); err != nil {
+		t.Fatal(err)
+	}
+	u, ok := c.User("200")
+	if !ok {
+		t.Fatal("user not found")
+	}
+	if u.Name != "Al Bee" || u.ShortName != "Al" || u.Gender != messenger.GenderMale {
+		t.Errorf("profile fields lost: %+v", u)
+	}
+	if u.Username != "al.bee" {
+		t.Errorf("expecting username %q, got %q", "al.bee", u.Username)
+	}
+	if !u.Blocked {
+		t.Error("blocked status lost")
+	}
+	if !u.Active || !u.LastActive.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("presence lost: %+v", u)
+	}
+}
+
+func TestRefreshUsers(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	log := newRequestLog(s)
+	c, err := s.Login(messenger.WithTransport(log), messenger.WithRateLimit(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateThreadList(messenger.FolderInbox); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	c.SetUser(messenger.User{ID: "200", Name: "Al Bee"})
+	log.take("")
+	if err = c.RefreshUsers(25 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	reqs := log.take("/chat/user_info/")
+	if len(reqs) != 1 {
+		t.Fatalf("expecting 1 user info request, got %d", len(reqs))
+	}
+	var ids []string
+	for key, values := range reqs[0].Form {
+		if len(key) > 4 && key[:4] == "ids[" {
+			ids = append(ids, values...)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "100" || ids[1] != "300" {
+		t.Errorf("expecting stale users [100 300], got %v", ids)
+	}
+	if err = c.RefreshUsers(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if reqs = log.take("/chat/user_info/"); len(reqs) != 0 {
+		t.Errorf("expecting no user info requests, got %d", len(reqs))
+	}
+}
@@ -0,0 +1,23 @@
+package messenger
+
+type Option func(*Client)
+
+func (c *Client) SetOptions(opts ...Option) {
+	for _, opt := range opts {
+		opt(c)
+	}
+}
+
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *Client) {
+		c.limiter.setRate(rate, burst)
+	}
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retryMu.Lock()
+		c.retry = &policy
+		c.retryMu.Unlock()
+	}
+}
@@ -0,0 +1,33 @@
+package messenger
+
+const (
+	queryThreadList = "threadList"
+	queryThread     = "thread"
+)
+
+var queryDocIDs = map[string]string{
+	queryThreadList: "MessengerGraphQLThreadlistFetcher",
+	queryThread:     "MessengerGraphQLThreadFetcher",
+}
+
+type graphQLQuery struct {
+	DocID       string      `json:"doc_id"`
+	QueryParams interface{} `json:"query_params"`
+}
+
+type threadListParams struct {
+	Limit                   int      `json:"limit"`
+	Before                  *string  `json:"before"`
+	Tags                    []string `json:"tags"`
+	IsWorkUser              int      `json:"isWorkUser"`
+	IncludeDeliveryReceipts bool     `json:"includeDeliveryReceipts"`
+	IncludeSeqID            bool     `json:"includeSeqID"`
+}
+
+type threadParams struct {
+	ID               string  `json:"id"`
+	MessageLimit     int     `json:"message_limit"`
+	LoadMessages     int     `json:"load_messages"`
+	LoadReadReceipts bool    `json:"load_read_receipts"`
+	Before           *string `json:"before"`
+}
@@ -0,0 +1,63 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestQueryEscaping(t *testing.T) {
+	ids := []string{
+		`"`,
+		`\`,
+		`\"`,
+		`a"b\c`,
+		"\x00\x01\x1f\x7f",
+		"\t\r\n\b\f",
+		"\u2028\u2029",
+		"</script><!--",
+		`{"id":"1"}`,
+		"\xff",
+	}
+	var body string
+	c := &Client{docIDs: map[string]string{queryDocIDs[queryThread]: "1"}}
+	c.client.Jar = newJar()
+	c.client.Transport = rtFunc(func(r *http.Request) (*http.Response, error) {
+		r.ParseForm()
+		body = r.PostForm.Get("queries")
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       io.NopCloser(strings.NewReader(`{"successful_results":0,"error_results":0,"skipped_results":0}`)),
+			Request:    r,
+		}, nil
+	})
+	c.SetOptions(WithRateLimit(0, 0))
+	b := c.Batch()
+	for _, id := range ids {
+		b.Thread(id)
+	}
+	b.Do(context.Background())
+	var queries map[string]struct {
+		DocID       string       `json:"doc_id"`
+		QueryParams threadParams `json:"query_params"`
+	}
+	if err := json.Unmarshal([]byte(body), &queries); err != nil {
+		t.Fatalf("invalid query JSON %q: %s", body, err)
+	} else if len(queries) != len(ids) {
+		t.Fatalf("expecting %d queries, got %d", len(ids), len(queries))
+	}
+	for n, id := range ids {
+		q := queries["o"+strconv.Itoa(n)]
+		if strings.ToValidUTF8(id, "\ufffd") != q.QueryParams.ID || q.DocID != "1" {
+			t.Errorf("test %d: expecting id %q, got %q", n+1, id, q.QueryParams.ID)
+		}
+	}
+	if strings.ContainsAny(body, "\x00\n\r\t\u2028\u2029<>") {
+		t.Errorf("unescaped characters in query: %q", body)
+	}
+}
@@ -0,0 +1,173 @@
+package messenger
+
+import (
+	"context"
+	stderrors "errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vimagination.zapto.org/errors"
+)
+
+const (
+	DefaultRate  = 2
+	DefaultBurst = 10
+)
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	MinBackoff: 500 * time.Millisecond,
+	MaxBackoff: 30 * time.Second,
+}
+
+type RetryPolicy struct {
+	MaxRetries             int
+	MinBackoff, MaxBackoff time.Duration
+}
+
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	d := r.MinBackoff
+	for i := 0; i < attempt && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+type tokenBucket struct {
+	mu            sync.Mutex
+	set           bool
+	rate, burst   float64
+	tokens        float64
+	last          time.Time
+	throttled     uint64
+	throttledTime time.Duration
+}
+
+func (t *tokenBucket) setRate(rate float64, burst int) {
+	t.mu.Lock()
+	t.set = true
+	t.rate = rate
+	t.burst = float64(burst)
+	t.tokens = t.burst
+	t.last = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *tokenBucket) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.set {
+		t.set = true
+		t.rate = DefaultRate
+		t.burst = DefaultBurst
+		t.tokens = DefaultBurst
+		t.last = time.Now()
+	}
+	if t.rate <= 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+	t.tokens--
+	var delay time.Duration
+	if t.tokens < 0 {
+		delay = time.Duration(-t.tokens / t.rate * float64(time.Second))
+		t.throttled++
+		t.throttledTime += delay
+	}
+	t.mu.Unlock()
+	return sleep(ctx, delay)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) retryPolicy() RetryPolicy {
+	c.retryMu.RLock()
+	defer c.retryMu.RUnlock()
+	if c.retry == nil {
+		return DefaultRetryPolicy
+	}
+	return *c.retry
+}
+
+func (c *Client) backoff(ctx context.Context, attempt int) bool {
+	policy := c.retryPolicy()
+	if attempt >= policy.MaxRetries {
+		return false
+	}
+	atomic.AddUint64(&c.retries, 1)
+	return sleep(ctx, policy.backoff(attempt)) == nil
+}
+
+type transientError struct {
+	error
+}
+
+func (t transientError) Unwrap() error {
+	return t.error
+}
+
+func checkTransient(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		if stderrors.Is(err, context.Canceled) || stderrors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		return nil, transientError{err}
+	}
+	if resp.StatusCode >= 500 {
+		resp.Body.Close()
+		return nil, transientError{ErrServerError}
+	}
+	return resp, nil
+}
+
+func isTransient(err error) bool {
+	var t transientError
+	return stderrors.As(err, &t)
+}
+
+type Stats struct {
+	Requests, Retries, Throttled uint64
+	ThrottledTime                time.Duration
+}
+
+func (c *Client) Stats() Stats {
+	c.limiter.mu.Lock()
+	throttled, throttledTime := c.limiter.throttled, c.limiter.throttledTime
+	c.limiter.mu.Unlock()
+	return Stats{
+		Requests:      atomic.LoadUint64(&c.requests),
+		Retries:       atomic.LoadUint64(&c.retries),
+		Throttled:     throttled,
+		ThrottledTime: throttledTime,
+	}
+}
+
+const (
+	ErrServerError errors.Error = "server error"
+)
@@ -0,0 +1,72 @@
+package messenger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var c Client
+	c.limiter.setRate(100, 2)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := c.limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+	stats := c.Stats()
+	if stats.Throttled != 4 {
+		t.Fatalf("expecting 4 throttled requests, got %d", stats.Throttled)
+	} else if stats.ThrottledTime <= 30*time.Millisecond || stats.ThrottledTime > 40*time.Millisecond {
+		t.Fatalf("expecting throttled time of about 40ms, got %s", stats.ThrottledTime)
+	} else if elapsed < stats.ThrottledTime {
+		t.Fatalf("expecting to wait at least %s, waited %s", stats.ThrottledTime, elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.limiter.wait(ctx); err != context.Canceled {
+		t.Fatalf("expecting context.Canceled, got %v", err)
+	}
+	c.limiter.setRate(0, 0)
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		c.limiter.wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Fatalf("disabled limiter waited %s", elapsed)
+	} else if stats := c.Stats(); stats.Throttled != 5 {
+		t.Fatalf("expecting 5 throttled requests, got %d", stats.Throttled)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, max := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := policy.backoff(attempt); d < max/2 || d > max {
+				t.Fatalf("attempt %d: expecting backoff between %s and %s, got %s", attempt, max/2, max, d)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(2); d != 0 {
+		t.Fatalf("expecting no backoff, got %s", d)
+	}
+	c := Client{retry: &RetryPolicy{MaxRetries: 2}}
+	for attempt, expected := range []bool{true, true, false} {
+		if got := c.backoff(context.Background(), attempt); got != expected {
+			t.Fatalf("attempt %d: expecting %v, got %v", attempt, expected, got)
+		}
+	}
+	if stats := c.Stats(); stats.Retries != 2 {
+		t.Fatalf("expecting 2 retries, got %d", stats.Retries)
+	}
+}
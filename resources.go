@@ -0,0 +1,144 @@
+package messenger
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vimagination.zapto.org/errors"
+)
+
+type ResourceLimits struct {
+	Workers int
+	Timeout time.Duration
+	MaxSize int64
+}
+
+var DefaultResourceLimits = ResourceLimits{
+	Workers: 4,
+	Timeout: 10 * time.Second,
+	MaxSize: 5 << 20,
+}
+
+func WithResourceLimits(limits ResourceLimits) Option {
+	return func(c *Client) {
+		c.resourceLimits = &limits
+	}
+}
+
+func (c *Client) getResourceLimits() ResourceLimits {
+	if c == nil || c.resourceLimits == nil {
+		return DefaultResourceLimits
+	}
+	return *c.resourceLimits
+}
+
+func resourceURLs(resources map[string][]string) []string {
+	keys := make([]string, 0, len(resources))
+	for key := range resources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	loaded := make(map[string]struct{})
+	var urls []string
+	for _, key := range keys {
+		for _, url := range resources[key] {
+			if _, ok := loaded[url]; !ok {
+				urls = append(urls, url)
+				loaded[url] = struct{}{}
+			}
+		}
+	}
+	return urls
+}
+
+func (c *Client) fetchResources(urls []string) ([]string, error) {
+	limits := c.getResourceLimits()
+	workers := limits.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(urls) {
+		workers = len(urls)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		ferr    error
+	)
+	scripts := make([]string, len(urls))
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				script, err := c.fetchResource(ctx, urls[n], limits)
+				if err != nil {
+					errOnce.Do(func() {
+						ferr = err
+						cancel()
+					})
+					continue
+				}
+				scripts[n] = script
+			}
+		}()
+	}
+Loop:
+	for n := range urls {
+		select {
+		case jobs <- n:
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if ferr != nil {
+		return nil, ferr
+	}
+	return scripts, nil
+}
+
+func (c *Client) fetchResource(ctx context.Context, url string, limits ResourceLimits) (string, error) {
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", errors.WithContext("error creating resource request: ", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", errors.WithContext("error getting resource: ", err)
+	}
+	defer resp.Body.Close()
+	var (
+		sb strings.Builder
+		r  io.Reader = resp.Body
+	)
+	if limits.MaxSize > 0 {
+		r = io.LimitReader(r, limits.MaxSize+1)
+	}
+	n, err := io.Copy(&sb, r)
+	if err != nil {
+		return "", errors.WithContext("error reading resource: ", err)
+	}
+	if limits.MaxSize > 0 && n > limits.MaxSize {
+		return "", ErrResourceTooLarge
+	}
+	return sb.String(), nil
+}
+
+const (
+	ErrResourceTooLarge errors.Error = "resource too large"
+)
@@ -1,13 +1,16 @@
+//go:build !nootto
+// +build !nootto
+
 package messenger
 
 import (
+	"sync"
 	"time"
 
-	"vimagination.zapto.org/errors"
 	"github.com/robertkrimen/otto"
 	"github.com/robertkrimen/otto/parser"
 	"github.com/robertkrimen/otto/registry"
-	xmlpath "gopkg.in/xmlpath.v2"
+	"vimagination.zapto.org/errors"
 )
 
 func init() {
@@ -19,12 +22,16 @@ func runtime() string {
 var setUserData = function() {},
 setDTSGToken = function() {},
 setSiteData = function() {},
-setCookieData = function() {},
+setLSD = function() {},
+setCookieValue = function() {},
 setSprinkleName = function() {},
 setBitmap = function() {},
 setResource = function() {},
 setID = function() {},
 setThreadData = function() {},
+getDocIDKey = function() { return ""; },
+isBootload = function() { return false; },
+handleDefine = function() {},
 requireObj = {
 	lastID: "",
         guard: function(a) {
@@ -48,11 +55,16 @@ requireObj = {
 					setDTSGToken(o["token"]);
 					break;
 				case "SiteData":
-					setSiteData(o["server_revision"], o["pkg_cohort_key"], o["pkg_cohort"], o["be_key"], o["be_mode"]);
+					setSiteData(o["server_revision"], o["pkg_cohort_key"], o["pkg_cohort"], o["be_key"], o["be_mode"], o["haste_session"], o["pr"]);
+					break;
+				case "LSD":
+					setLSD(o["token"]);
 					break;
 				case "SprinkleConfig":
 					setSprinkleName(o["param_name"]);
 					break;
+				default:
+					handleDefine(data[i][0], JSON.stringify(o));
 				}
 			}
 		}
@@ -70,9 +82,7 @@ requireObj = {
 			if (data["require"]) {
 				for (var i = 0; i < data["require"].length; i++) {
 					if (data["require"][i][0] === "MessengerMount") {
-						var obj = {o0: {"data" : {}}};
-						obj["o0"].data = data["require"][i][3][1]["graphqlPayload"]["thread_list"];
-						setThreadData(JSON.stringify(obj));
+						setThreadData(JSON.stringify(data["require"][i][3][1]["graphqlPayload"]["thread_list"]));
 						break;
 					}
 				}
@@ -89,15 +99,13 @@ bootloader = {
 	enableBootload: function(data) {
 		for (key in data) {
 			var d = data[key];
-			switch (key) {
-			case "MessengerGraphQLThreadlistFetcher.bs":
+			if (isBootload(key)) {
 				for (var i = 0; i < d["resources"].length; i++) {
 					var res = this.resourceMap[d["resources"][i]];
 					if (res && res.type === "js") {
-						setResource("MessengerGraphQLThreadlistFetcher.bs", res.src);
+						setResource(key, res.src, res.p);
 					}
 				}
-				break;
 			}
 		}
 	}
@@ -119,17 +127,11 @@ require = function(id) {
 	}
 },
 __d = function(name, requires, func) {
-	switch (name) {
-	case "MessengerThreadlistWebGraphQLQuery":
+	var key = getDocIDKey(name);
+	if (key !== "") {
 		var obj = {};
 		func(null, function(){}, null, null, obj, null);
-		setID("MessengerGraphQLThreadlistFetcher", obj.exports.__getDocID());
-		break;
-	case "MessengerThreadsWebGraphQLQuery":
-		var obj = {};
-		func(null, function(){}, null, null, obj, null);
-		setID("MessengerGraphQLThreadFetcher", obj.exports.__getDocID());
-		break;
+		setID(key, obj.exports.__getDocID());
 	}
 },
 bigPipe = {
@@ -199,14 +201,6 @@ type Stringer interface {
 	String() string
 }
 
-type xmlPathIter struct {
-	*xmlpath.Iter
-}
-
-func (x xmlPathIter) Node() Stringer {
-	return x.Iter.Node()
-}
-
 type stringIter []string
 
 func (s *stringIter) Next() bool {
@@ -225,58 +219,141 @@ func (s stringer) String() string {
 	return string(s)
 }
 
-func runCode(funcs jsFuncs, scripts Iter) (err error) {
+func wrapJSFunc(fn JSFunc) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		args := make([]string, len(call.ArgumentList))
+		for n, arg := range call.ArgumentList {
+			args[n] = jsOptString(arg)
+		}
+		v, _ := otto.ToValue(fn(args))
+		return v
+	}
+}
+
+func runCode(config *jsConfig, funcs jsFuncs, scripts Iter) (stats []ScriptStat, err error) {
+	var (
+		index int
+		hash  string
+	)
 	defer func() {
 		if errp := recover(); errp != nil {
 			if errp == jsHalt {
-				err = jsHalt
+				err = ScriptError{
+					Index: index,
+					Hash:  hash,
+					Err:   jsHalt,
+				}
 			} else {
 				panic(errp)
 			}
 		}
 	}()
+	if config.err != nil {
+		return nil, config.err
+	}
+	limits := config.getLimits()
 	vm := otto.New()
+	if limits.MaxStackDepth > 0 {
+		vm.SetStackDepthLimit(limits.MaxStackDepth)
+	}
+	for name, fn := range config.funcs {
+		vm.Set(name, wrapJSFunc(fn))
+	}
 	for name, fn := range funcs {
 		vm.Set(name, fn)
 	}
 	vm.Interrupt = make(chan func(), 1)
-	reset := make(chan bool, 1)
-	const resetTime = time.Second
-	go func() {
-		timer := time.NewTimer(resetTime)
-		for {
-			select {
-			case <-timer.C:
-				vm.Interrupt <- func() {
-					panic(jsHalt)
-				}
-			case cont := <-reset:
-				if !cont {
-					timer.Stop()
-					return
+	var (
+		mu              sync.Mutex
+		running, halted bool
+		current         int
+	)
+	halt := func() {
+		panic(jsHalt)
+	}
+	interrupt := func(script int) func() {
+		return func() {
+			mu.Lock()
+			if script < 0 {
+				halted = true
+			}
+			if running && (script < 0 || script == current) {
+				select {
+				case vm.Interrupt <- halt:
+				default:
 				}
-				timer.Reset(resetTime)
 			}
+			mu.Unlock()
+		}
+	}
+	if limits.TotalTimeout > 0 {
+		total := time.AfterFunc(limits.TotalTimeout, interrupt(-1))
+		defer total.Stop()
+	}
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
 		}
 	}()
-	for scripts.Next() {
-		program, err := parser.ParseFile(nil, "", scripts.Node().String(), parser.IgnoreRegExpErrors)
+	for index = 0; scripts.Next(); index++ {
+		script := scripts.Node().String()
+		hash = scriptHash(script)
+		if limits.MaxScriptSize > 0 && len(script) > limits.MaxScriptSize {
+			return stats, ScriptError{
+				Index: index,
+				Hash:  hash,
+				Err:   ErrScriptTooLarge,
+			}
+		}
+		start := time.Now()
+		program, err := parser.ParseFile(nil, "", script, parser.IgnoreRegExpErrors)
 		if err != nil {
-			return errors.WithContext("error parsing script: ", err)
+			return stats, ScriptError{
+				Index: index,
+				Hash:  hash,
+				Err:   errors.WithContext("error parsing script: ", err),
+			}
+		}
+		mu.Lock()
+		if halted {
+			mu.Unlock()
+			panic(jsHalt)
+		}
+		running = true
+		current = index
+		mu.Unlock()
+		if limits.ScriptTimeout > 0 {
+			timer = time.AfterFunc(limits.ScriptTimeout, interrupt(index))
 		}
 		_, err = vm.Run(program)
-		reset <- true
+		if timer != nil {
+			timer.Stop()
+		}
+		mu.Lock()
+		running = false
+		select {
+		case <-vm.Interrupt:
+		default:
+		}
+		mu.Unlock()
+		stats = append(stats, ScriptStat{
+			Hash:     hash,
+			Size:     len(script),
+			Duration: time.Since(start),
+		})
 		if err != nil {
 			if strerr, ok := err.(interface {
 				String() string
 			}); ok {
-				return errors.Error(strerr.String())
+				err = errors.Error(strerr.String())
+			}
+			return stats, ScriptError{
+				Index: index,
+				Hash:  hash,
+				Err:   err,
 			}
-			return err
 		}
 	}
-	close(reset)
-	close(vm.Interrupt)
-
-	return nil
+	return stats, nil
 }
@@ -0,0 +1,24 @@
+//go:build !nootto
+// +build !nootto
+
+package messenger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/robertkrimen/otto"
+)
+
+func TestRuntimeGlobals(t *testing.T) {
+	v, err := otto.New().Run("Object.keys(this).join(',')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := v.ToString()
+	for _, name := range strings.Split(keys, ",") {
+		if _, ok := jsRuntimeGlobals[name]; !ok {
+			t.Errorf("runtime global %q not reserved", name)
+		}
+	}
+}
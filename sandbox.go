@@ -0,0 +1,149 @@
+package messenger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"vimagination.zapto.org/errors"
+)
+
+type JSLimits struct {
+	ScriptTimeout, TotalTimeout  time.Duration
+	MaxScriptSize, MaxStackDepth int
+}
+
+var DefaultJSLimits = JSLimits{
+	ScriptTimeout: time.Second,
+}
+
+type jsConfig struct {
+	limits *JSLimits
+	funcs  map[string]JSFunc
+	err    error
+}
+
+type JSFunc func(args []string) string
+
+var jsRuntimeGlobals = map[string]struct{}{
+	"setUserData":         {},
+	"setDTSGToken":        {},
+	"setSiteData":         {},
+	"setLSD":              {},
+	"setCookieValue":      {},
+	"setSprinkleName":     {},
+	"setBitmap":           {},
+	"setResource":         {},
+	"setID":               {},
+	"setThreadData":       {},
+	"getDocIDKey":         {},
+	"isBootload":          {},
+	"handleDefine":        {},
+	"requireObj":          {},
+	"requireConstructor":  {},
+	"bootloader":          {},
+	"requireLazy":         {},
+	"require":             {},
+	"__d":                 {},
+	"bigPipe":             {},
+	"bigPipeConstructor":  {},
+	"babelHelpers":        {},
+	"Document":            {},
+	"Element":             {},
+	"HTMLElement":         {},
+	"HTMLInputElement":    {},
+	"HTMLTextAreaElement": {},
+	"Range":               {},
+	"MouseEvent":          {},
+	"CSSStyleDeclaration": {},
+	"window":              {},
+	"self":                {},
+	"__p":                 {},
+	"console":             {},
+}
+
+func WithJSFunc(name string, fn JSFunc) Option {
+	return func(c *Client) {
+		if _, ok := jsRuntimeGlobals[name]; ok {
+			c.js.err = ErrReservedJSFunc
+			return
+		}
+		if c.js.funcs == nil {
+			c.js.funcs = make(map[string]JSFunc)
+		}
+		c.js.funcs[name] = fn
+	}
+}
+
+func WithJSLimits(limits JSLimits) Option {
+	return func(c *Client) {
+		c.js.limits = &limits
+	}
+}
+
+func (c *Client) sandbox() *jsConfig {
+	if c == nil {
+		return new(jsConfig)
+	}
+	return &c.js
+}
+
+func (j *jsConfig) getLimits() JSLimits {
+	if j.limits == nil {
+		return DefaultJSLimits
+	}
+	return *j.limits
+}
+
+type ScriptStat struct {
+	Hash     string
+	Size     int
+	Duration time.Duration
+}
+
+type ScriptError struct {
+	Index int
+	Hash  string
+	Err   error
+}
+
+func (s ScriptError) Error() string {
+	return "script " + strconv.Itoa(s.Index) + " (" + s.Hash + "): " + s.Err.Error()
+}
+
+func (s ScriptError) Unwrap() error {
+	return s.Err
+}
+
+func scriptHash(script string) string {
+	h := sha256.Sum256([]byte(script))
+	return hex.EncodeToString(h[:8])
+}
+
+func (c *Client) ScriptStats() []ScriptStat {
+	c.dataMu.RLock()
+	stats := append([]ScriptStat(nil), c.scriptStats...)
+	c.dataMu.RUnlock()
+	return stats
+}
+
+func (c *Client) addScriptStats(stats []ScriptStat) {
+	if c == nil {
+		return
+	}
+	c.dataMu.Lock()
+	c.scriptStats = append(c.scriptStats, stats...)
+	c.dataMu.Unlock()
+}
+
+func (c *Client) resetScriptStats() {
+	c.dataMu.Lock()
+	c.scriptStats = nil
+	c.dataMu.Unlock()
+}
+
+const (
+	ErrScriptTooLarge errors.Error = "script too large"
+	ErrReservedJSFunc errors.Error = "JS function name is reserved by the runtime"
+)
@@ -0,0 +1,77 @@
+package messenger
+
+import (
+	"context"
+	"sync/atomic"
+
+	"vimagination.zapto.org/errors"
+)
+
+func isStaleSession(err error) bool {
+	return isAPIError(err, errCodeNotLoggedIn, errCodeInvalidToken, errCodeInvalidDocID)
+}
+
+func (c *Client) OnSessionRefresh(fn func(error)) {
+	c.refreshMu.Lock()
+	c.onRefresh = fn
+	c.refreshMu.Unlock()
+}
+
+func (c *Client) withRetry(ctx context.Context, idempotent bool, fn func() error) error {
+	gen := atomic.LoadUint64(&c.sessionGen)
+	refreshed := false
+	for attempt := 0; ; {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if isStaleSession(err) && !refreshed {
+			if isAPIError(err, errCodeInvalidDocID) {
+				c.invalidateDocIDs()
+			}
+			if err = c.refresh(gen); err != nil {
+				return err
+			}
+			refreshed = true
+			continue
+		}
+		if !(IsRateLimited(err) || idempotent && isTransient(err)) || !c.backoff(ctx, attempt) {
+			return err
+		}
+		attempt++
+	}
+}
+
+func (c *Client) refresh(gen uint64) error {
+	c.refreshMu.Lock()
+	if atomic.LoadUint64(&c.sessionGen) != gen {
+		c.refreshMu.Unlock()
+		return nil
+	}
+	err := c.init()
+	if err == nil {
+		atomic.AddUint64(&c.sessionGen, 1)
+	} else {
+		err = errors.WithContext("error refreshing session: ", err)
+	}
+	onRefresh := c.onRefresh
+	c.refreshMu.Unlock()
+	if onRefresh != nil {
+		onRefresh(err)
+	}
+	return err
+}
+
+func (c *Client) userID() string {
+	c.dataMu.RLock()
+	id := c.postData.Get("__user")
+	c.dataMu.RUnlock()
+	return id
+}
+
+func (c *Client) docID(name string) string {
+	c.dataMu.RLock()
+	id := c.docIDs[name]
+	c.dataMu.RUnlock()
+	return id
+}